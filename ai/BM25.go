@@ -0,0 +1,223 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: k1 saturates term frequency, b normalizes by chunk length
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25Index is an inverted index over a user's chunks for lexical search
+type BM25Index struct {
+	Version    int64                    `json:"version"` // DatasetStore.Version of the chunks the index was built from
+	ChunkIDs   []string                 `json:"chunk_ids"`
+	DatasetIDs []int                    `json:"dataset_ids"` // dataset of each chunk
+	Lengths    []int                    `json:"lengths"`     // terms per chunk
+	AvgLength  float64                  `json:"avg_length"`
+	Postings   map[string][]bm25Posting `json:"postings"`
+}
+
+// bm25Posting is the number of times a term appears in one chunk
+type bm25Posting struct {
+	Doc int `json:"d"`
+	TF  int `json:"f"`
+}
+
+// bm25Hit is a chunk matching a query and its score
+type bm25Hit struct {
+	ChunkID   string
+	DatasetID int
+	Score     float64
+}
+
+// stopwords are too common to say anything about relevance
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"can": true, "do": true, "does": true, "for": true, "from": true, "how": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "which": true,
+	"who": true, "why": true, "with": true,
+}
+
+// tokenize splits text into lowercase terms, dropping stopwords
+func tokenize(text string) []string {
+	var terms []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !stopwords[w] {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// BuildBM25Index indexes the chunks, which the store holds at version
+func BuildBM25Index(chunks []Chunk, version int64) *BM25Index {
+	idx := &BM25Index{Version: version, Postings: map[string][]bm25Posting{}}
+
+	total := 0
+	for doc, c := range chunks {
+		terms := tokenize(c.Text)
+		idx.ChunkIDs = append(idx.ChunkIDs, c.ChunkID)
+		idx.DatasetIDs = append(idx.DatasetIDs, c.DatasetID)
+		idx.Lengths = append(idx.Lengths, len(terms))
+		total += len(terms)
+
+		tf := map[string]int{}
+		var order []string
+		for _, t := range terms {
+			if tf[t] == 0 {
+				order = append(order, t)
+			}
+			tf[t]++
+		}
+		for _, t := range order {
+			idx.Postings[t] = append(idx.Postings[t], bm25Posting{Doc: doc, TF: tf[t]})
+		}
+	}
+	if len(chunks) > 0 {
+		idx.AvgLength = float64(total) / float64(len(chunks))
+	}
+	return idx
+}
+
+// Search returns up to k chunks matching the query, best first, keeping only chunks
+// whose dataset is allowed (nil allows all)
+func (idx *BM25Index) Search(query string, k int, allow func(datasetID int) bool) []bm25Hit {
+	n := float64(len(idx.ChunkIDs))
+	scores := make([]float64, len(idx.ChunkIDs))
+	seen := map[string]bool{}
+	for _, t := range tokenize(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		postings := idx.Postings[t]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.TF)
+			norm := 1 - bm25B + bm25B*float64(idx.Lengths[p.Doc])/max(idx.AvgLength, 1)
+			scores[p.Doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	// Scored in index order, so ties keep the order of the chunks
+	var scored []ScoredChunk
+	for doc, score := range scores {
+		if score > 0 && (allow == nil || allow(idx.DatasetIDs[doc])) {
+			scored = append(scored, ScoredChunk{Chunk: Chunk{ChunkID: idx.ChunkIDs[doc], DatasetID: idx.DatasetIDs[doc]}, Score: score})
+		}
+	}
+
+	var hits []bm25Hit
+	for _, s := range topK(scored, k) {
+		hits = append(hits, bm25Hit{ChunkID: s.ChunkID, DatasetID: s.DatasetID, Score: s.Score})
+	}
+	return hits
+}
+
+// loadBM25Index reads a user's saved index, nil if there is none
+func loadBM25Index(ctx context.Context, s DatasetStore, userID int) (*BM25Index, error) {
+	data, err := s.LoadSearchIndex(ctx, userID)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var idx BM25Index
+	if err := json.Unmarshal(data, &idx); err != nil || len(idx.DatasetIDs) != len(idx.ChunkIDs) {
+		// A damaged index, or one from before datasets were recorded, is rebuilt from the chunks
+		fmt.Println("Ignoring unreadable BM25 index of user", userID)
+		return nil, nil
+	}
+	return &idx, nil
+}
+
+// saveBM25Index saves a user's index next to their chunks
+func saveBM25Index(ctx context.Context, s DatasetStore, userID int, idx *BM25Index) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return s.SaveSearchIndex(ctx, userID, idx.Version, data)
+}
+
+// updateBM25Index rebuilds a user's index from the chunks in the dataset store
+func updateBM25Index(ctx context.Context, s DatasetStore, userID int) error {
+	// Read the version first: if the chunks change meanwhile, the index looks older than it is
+	version, err := s.Version(ctx, userID)
+	if err != nil {
+		return err
+	}
+	chunks, err := s.LoadUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return saveBM25Index(ctx, s, userID, BuildBM25Index(chunks, version))
+}
+
+// BM25Retriever ranks chunks with the user's saved BM25 index and only loads the chunks
+// it returns. The index is rebuilt when the store's version of the chunks has moved on.
+type BM25Retriever struct{}
+
+func (r *BM25Retriever) Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error) {
+	s := user.store
+	version, err := s.Version(ctx, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := loadBM25Index(ctx, s, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil || idx.Version != version {
+		// The index covers all of the user's chunks, whichever datasets are asked for
+		chunks, err := user.LoadAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		idx = BuildBM25Index(chunks, version)
+		if err := saveBM25Index(ctx, s, user.UserID, idx); err != nil {
+			return nil, err
+		}
+	}
+
+	hits := idx.Search(question, k, user.Allows)
+	if len(hits) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ChunkID
+	}
+	chunks, err := s.LoadChunks(ctx, user.UserID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error loading chunks: %v", err)
+	}
+	byID := make(map[string]Chunk, len(chunks))
+	for _, c := range chunks {
+		byID[c.ChunkID] = c
+	}
+
+	var results []ScoredChunk
+	for _, hit := range hits {
+		// Chunks replaced since the version was read are skipped
+		if c, ok := byID[hit.ChunkID]; ok {
+			results = append(results, ScoredChunk{Chunk: c, Score: hit.Score})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"context"
+	"testing"
+)
+
+func bm25TestChunk(datasetID int, id, text string) Chunk {
+	return Chunk{Version: ChunkSchemaVersion, DatasetID: datasetID, ChunkID: id, Source: "notes.pdf", Text: text, Hash: hashText(text)}
+}
+
+func TestBM25IndexSearch(t *testing.T) {
+	idx := BuildBM25Index([]Chunk{
+		bm25TestChunk(1, "1-1", "Photosynthesis turns light into chemical energy."),
+		bm25TestChunk(1, "1-2", "Mitochondria release energy from glucose."),
+		bm25TestChunk(2, "2-1", "The French revolution began in 1789."),
+	}, 3)
+
+	hits := idx.Search("Where is energy from light stored?", 0, nil)
+	if len(hits) != 2 || hits[0].ChunkID != "1-1" || hits[1].ChunkID != "1-2" {
+		t.Fatalf("hits = %+v, want 1-1 then 1-2", hits)
+	}
+	if hits := idx.Search("energy", 1, nil); len(hits) != 1 {
+		t.Errorf("got %d hits with k=1", len(hits))
+	}
+	if hits := idx.Search("energy revolution", 0, func(id int) bool { return id == 2 }); len(hits) != 1 || hits[0].ChunkID != "2-1" {
+		t.Errorf("hits limited to dataset 2 = %+v", hits)
+	}
+	if hits := idx.Search("the of what", 0, nil); len(hits) != 0 {
+		t.Errorf("stopwords matched %+v", hits)
+	}
+}
+
+func TestBM25RetrieverFollowsStoreVersion(t *testing.T) {
+	ctx := context.Background()
+	s := &FileStore{Root: t.TempDir()}
+	if err := s.ReplaceDataset(ctx, 7, 1, []Chunk{bm25TestChunk(1, "1-1", "Binary search halves the range.")}); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := s.Version(ctx, 7)
+	if err != nil || v1 == 0 {
+		t.Fatalf("version after the first write = %d, %v", v1, err)
+	}
+
+	r := &BM25Retriever{}
+	got, err := r.Retrieve(ctx, NewUserChunks(s, 7), "binary search", 5)
+	if err != nil || len(got) != 1 || got[0].Text != "Binary search halves the range." {
+		t.Fatalf("Retrieve = %+v, %v", got, err)
+	}
+	if idx, err := loadBM25Index(ctx, s, 7); err != nil || idx == nil || idx.Version != v1 {
+		t.Fatalf("saved index = %+v, %v; want version %d", idx, err, v1)
+	}
+
+	// A new dataset moves the version on, so the next question sees its chunks
+	if err := s.ReplaceDataset(ctx, 7, 2, []Chunk{bm25TestChunk(2, "2-1", "Quick sort partitions around a pivot.")}); err != nil {
+		t.Fatal(err)
+	}
+	if v2, _ := s.Version(ctx, 7); v2 <= v1 {
+		t.Fatalf("version did not move on: %d -> %d", v1, v2)
+	}
+	got, err = r.Retrieve(ctx, NewUserChunks(s, 7), "pivot", 5)
+	if err != nil || len(got) != 1 || got[0].ChunkID != "2-1" {
+		t.Fatalf("Retrieve after a new dataset = %+v, %v", got, err)
+	}
+
+	user := NewUserChunks(s, 7)
+	user.DatasetIDs = []int{1}
+	if got, _ := r.Retrieve(ctx, user, "pivot", 5); len(got) != 0 {
+		t.Errorf("dataset filter let through %+v", got)
+	}
+}
+
+func TestFileStoreKeepsNewerSearchIndex(t *testing.T) {
+	ctx := context.Background()
+	s := &FileStore{Root: t.TempDir()}
+	if err := s.ReplaceDataset(ctx, 7, 1, []Chunk{bm25TestChunk(1, "1-1", "Binary search halves the range.")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveSearchIndex(ctx, 7, 3, []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	// A slower rebuild that started from older chunks finishes last
+	if err := s.SaveSearchIndex(ctx, 7, 2, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.LoadSearchIndex(ctx, 7); err != nil || string(data) != "v3" {
+		t.Fatalf("saved index = %q, %v; want v3", data, err)
+	}
+
+	if err := s.SaveSearchIndex(ctx, 7, 3, []byte("v3 again")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := s.LoadSearchIndex(ctx, 7); string(data) != "v3 again" {
+		t.Errorf("index of the same version was not saved: %q", data)
+	}
+}
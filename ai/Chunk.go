@@ -0,0 +1,76 @@
+package ai
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// ChunkSchemaVersion is the version of the Chunk layout written to datasets.
+// Bump it whenever a field changes meaning, and teach the migrator the old layout.
+const ChunkSchemaVersion = 1
+
+// Chunk is one retrievable piece of a dataset. The ingestion pipeline writes
+// chunks and QASystem reads them back, so both sides share this one schema.
+type Chunk struct {
+	Version     int      `json:"v"`
+	DatasetID   int      `json:"dataset_id"`
+	ChunkID     string   `json:"chunk_id"`
+	Source      string   `json:"source"`               // filename of the upload
+	PageStart   int      `json:"page_start,omitempty"` // documents: pages covered
+	PageEnd     int      `json:"page_end,omitempty"`
+	TimeStart   float64  `json:"time_start,omitempty"` // recordings: seconds covered
+	TimeEnd     float64  `json:"time_end,omitempty"`
+	HeadingPath []string `json:"heading_path,omitempty"` // enclosing headings, outermost first
+	Text        string   `json:"text"`
+	Hash        string   `json:"hash"` // sha256 of Text
+
+	Embedding      []float32 `json:"embedding,omitempty"`
+	EmbeddingModel string    `json:"embedding_model,omitempty"` // Embedder.Name() of the model that computed Embedding
+}
+
+// Source identifies the dataset a set of chunks is extracted from
+type Source struct {
+	UserID    int
+	DatasetID int
+	Filename  string
+}
+
+// IsTimed reports whether the chunk comes from an audio or video recording
+func (c *Chunk) IsTimed() bool {
+	return c.TimeEnd > 0
+}
+
+// Location describes where the chunk is in its source, e.g. "p. 3-4" or "23:10-28:00"
+func (c *Chunk) Location() string {
+	switch {
+	case c.IsTimed():
+		return formatTimestamp(c.TimeStart) + "-" + formatTimestamp(c.TimeEnd)
+	case c.PageStart > 0 && c.PageEnd > c.PageStart:
+		return fmt.Sprintf("p. %d-%d", c.PageStart, c.PageEnd)
+	case c.PageStart > 0:
+		return fmt.Sprintf("p. %d", c.PageStart)
+	}
+	return ""
+}
+
+// hashText returns the content hash stored on chunks
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeChunks renders chunks as JSON Lines, one chunk per line
+func encodeChunks(chunks []Chunk) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, c := range chunks {
+		if err := enc.Encode(c); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,235 @@
+package ai
+
+import (
+	"regexp"
+	"strings"
+)
+
+// headingLine matches the heading markup the cleanup prompts ask the LLM for:
+// **Heading**, *Subheading* or markdown #, ## and ### headings
+var headingLine = regexp.MustCompile(`^(?:\*\*([^*].*?)\*\*|\*([^*].*?)\*|(#{1,3})\s+(.+?))\s*:?$`)
+
+// timestampMarker matches the [m:ss] marker starting a sentence of a cleaned transcript
+// or a slide merged into it
+var timestampMarker = regexp.MustCompile(`^\[(?:(\d+):)?(\d+):(\d{2})\]`)
+
+// parseHeading returns the text and level (1 for the outermost) of a heading line
+func parseHeading(line string) (string, int, bool) {
+	m := headingLine.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		return "", 0, false
+	case m[1] != "":
+		return strings.TrimSpace(m[1]), 1, true
+	case m[2] != "":
+		return strings.TrimSpace(m[2]), 2, true
+	default:
+		return strings.TrimSpace(m[4]), len(m[3]), true
+	}
+}
+
+// chunkUnit is the smallest piece of text the chunker moves around: a heading line
+// or a sentence, with where it came from
+type chunkUnit struct {
+	Text    string
+	Sep     string // separator before the unit: "\n\n", "\n" or " "
+	Tokens  int
+	Page    int
+	Start   float64 // recordings: seconds into the recording
+	End     float64
+	Heading bool
+
+	timed   bool // the unit comes from a recording
+	pageEnd float64
+}
+
+// chunkSection is the text under one heading path
+type chunkSection struct {
+	Path  []string
+	Units []chunkUnit
+}
+
+// Chunker splits cleaned text into chunks of at most MaxTokens along its headings.
+// Consecutive chunks of a section share up to Overlap tokens of text.
+type Chunker struct {
+	MaxTokens int
+	Overlap   int
+}
+
+// newChunker builds a chunker from CHUNK_MAX_TOKENS and CHUNK_OVERLAP_TOKENS
+func newChunker() *Chunker {
+	maxTokens := max(envInt("CHUNK_MAX_TOKENS", 400), 1)
+	return &Chunker{
+		MaxTokens: maxTokens,
+		Overlap:   min(max(envInt("CHUNK_OVERLAP_TOKENS", 50), 0), maxTokens/2),
+	}
+}
+
+// Split chunks the pages of a document, recording or image. The returned chunks carry
+// their page or time range, heading path and text; IDs and hashes are set by the caller.
+func (ck *Chunker) Split(pages []PageData) []Chunk {
+	var chunks []Chunk
+	for _, section := range ck.sections(pages) {
+		chunks = append(chunks, ck.pack(section)...)
+	}
+	return chunks
+}
+
+// sections walks the lines of all pages, starting a new section at every heading
+func (ck *Chunker) sections(pages []PageData) []chunkSection {
+	var sections []chunkSection
+	var path []string
+	current := chunkSection{}
+	flush := func() {
+		if len(current.Units) > 0 {
+			sections = append(sections, current)
+		}
+	}
+
+	for _, p := range pages {
+		start := p.Start
+		sep := "\n\n" // pages start a new paragraph
+		add := func(u chunkUnit) {
+			u.Tokens = estimateTokens(u.Text)
+			u.Page, u.Start, u.timed, u.pageEnd = p.Page, start, p.End > 0, p.End
+			current.Units = append(current.Units, u)
+		}
+
+		for _, line := range strings.Split(pageText(p), "\n") {
+			line = strings.Join(strings.Fields(line), " ")
+			if line == "" {
+				sep = "\n\n"
+				continue
+			}
+
+			if text, level, ok := parseHeading(line); ok {
+				flush()
+				level = min(level, len(path)+1)
+				path = append(append([]string{}, path[:level-1]...), text)
+				current = chunkSection{Path: path}
+				add(chunkUnit{Text: line, Sep: "\n\n", Heading: true})
+				sep = "\n"
+				continue
+			}
+
+			for i, sentence := range ck.sentences(line) {
+				if m := timestampMarker.FindStringSubmatch(sentence); m != nil {
+					// A marker the LLM misplaced must not move the timeline backwards
+					start = max(start, clockSeconds(m[1], m[2], m[3]))
+				}
+				if i > 0 {
+					sep = " "
+				}
+				add(chunkUnit{Text: sentence, Sep: sep})
+			}
+			sep = "\n"
+		}
+	}
+	flush()
+
+	var units []*chunkUnit
+	for s := range sections {
+		for u := range sections[s].Units {
+			units = append(units, &sections[s].Units[u])
+		}
+	}
+	// A heading starts with the text under it, which may carry the next timestamp
+	for i := len(units) - 2; i >= 0; i-- {
+		if units[i].Heading && units[i+1].Page == units[i].Page {
+			units[i].Start = units[i+1].Start
+		}
+	}
+	// A unit lasts until the next one starts, the last of a page until the end of the page
+	for i, u := range units {
+		u.End = u.pageEnd
+		if i+1 < len(units) && units[i+1].Page == u.Page {
+			u.End = max(units[i+1].Start, u.Start)
+		}
+	}
+	return sections
+}
+
+// sentences splits a line after ., ! and ?, and splits sentences over the token
+// budget into budget-sized runs of words
+func (ck *Chunker) sentences(line string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		if strings.IndexByte(".!?", line[i]) >= 0 && i+1 < len(line) && line[i+1] == ' ' {
+			parts = append(parts, line[start:i+1])
+			start = i + 2
+		}
+	}
+	if start < len(line) {
+		parts = append(parts, line[start:])
+	}
+
+	var out []string
+	for _, part := range parts {
+		if estimateTokens(part) <= ck.MaxTokens {
+			out = append(out, part)
+			continue
+		}
+		var run []string
+		for _, w := range strings.Fields(part) {
+			if len(run) > 0 && estimateTokens(strings.Join(append(run, w), " ")) > ck.MaxTokens {
+				out = append(out, strings.Join(run, " "))
+				run = nil
+			}
+			run = append(run, w)
+		}
+		if len(run) > 0 {
+			out = append(out, strings.Join(run, " "))
+		}
+	}
+	return out
+}
+
+// pack fills chunks with the units of a section up to the token budget, starting each
+// chunk after the first with the trailing units of the previous one as overlap
+func (ck *Chunker) pack(section chunkSection) []Chunk {
+	var chunks []Chunk
+	units := section.Units
+	for start := 0; start < len(units); {
+		end, tokens := start, 0
+		for end < len(units) && (end == start || tokens+units[end].Tokens <= ck.MaxTokens) {
+			tokens += units[end].Tokens
+			end++
+		}
+		chunks = append(chunks, buildChunk(section.Path, units[start:end]))
+		if end == len(units) {
+			break
+		}
+
+		next, overlap := end, 0
+		for next-1 > start && !units[next-1].Heading && overlap+units[next-1].Tokens <= ck.Overlap {
+			next--
+			overlap += units[next].Tokens
+		}
+		start = next
+	}
+	return chunks
+}
+
+// buildChunk joins units into a chunk spanning their pages or time range
+func buildChunk(path []string, units []chunkUnit) Chunk {
+	var sb strings.Builder
+	for i, u := range units {
+		if i > 0 {
+			sb.WriteString(u.Sep)
+		}
+		sb.WriteString(u.Text)
+	}
+
+	first, last := units[0], units[len(units)-1]
+	c := Chunk{
+		PageStart:   first.Page,
+		PageEnd:     last.Page,
+		HeadingPath: path,
+		Text:        sb.String(),
+	}
+	if first.timed {
+		c.TimeStart, c.TimeEnd = first.Start, last.End
+	}
+	return c
+}
@@ -0,0 +1,180 @@
+package ai
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wordCounter counts one token per word, so budgets in the tests are easy to follow
+type wordCounter struct{}
+
+func (wordCounter) CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+func useWordCounter(t *testing.T) {
+	SetTokenCounter(wordCounter{})
+	t.Cleanup(func() { SetTokenCounter(HeuristicTokenCounter{}) })
+}
+
+func TestChunkerTokenBudget(t *testing.T) {
+	useWordCounter(t)
+	ck := &Chunker{MaxTokens: 10, Overlap: 3}
+
+	text := "**Sorting**\n" +
+		"Bubble sort swaps neighbours. It is slow on large inputs. " +
+		"Merge sort splits the list in half. It merges the sorted halves. " +
+		"Quick sort picks a pivot and partitions around it and then recurses on both sides of the pivot until done."
+	chunks := ck.Split([]PageData{{Page: 1, Text: text}})
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want the text split by the budget", len(chunks))
+	}
+
+	for i, c := range chunks {
+		if n := estimateTokens(c.Text); n > ck.MaxTokens {
+			t.Errorf("chunk %d has %d tokens, over the budget of %d: %q", i, n, ck.MaxTokens, c.Text)
+		}
+		if !reflect.DeepEqual(c.HeadingPath, []string{"Sorting"}) {
+			t.Errorf("chunk %d heading path = %q", i, c.HeadingPath)
+		}
+		if c.PageStart != 1 || c.PageEnd != 1 || c.IsTimed() {
+			t.Errorf("chunk %d location = %s", i, c.Location())
+		}
+	}
+
+	// Chunks after the first start with at most Overlap tokens of the previous chunk
+	for i := 1; i < len(chunks); i++ {
+		prev, cur := strings.Fields(chunks[i-1].Text), strings.Fields(chunks[i].Text)
+		shared := 0
+		for n := min(len(prev), len(cur)); n > 0; n-- {
+			if reflect.DeepEqual(prev[len(prev)-n:], cur[:n]) {
+				shared = n
+				break
+			}
+		}
+		if shared > ck.Overlap {
+			t.Errorf("chunk %d repeats %d tokens of chunk %d, over the overlap of %d", i, shared, i-1, ck.Overlap)
+		}
+	}
+
+	// The sentence over the budget is cut into runs of words, not dropped
+	for _, w := range []string{"Quick", "recurses", "done."} {
+		if !containsWord(chunks, w) {
+			t.Errorf("no chunk contains %q", w)
+		}
+	}
+}
+
+func containsWord(chunks []Chunk, w string) bool {
+	for _, c := range chunks {
+		for _, f := range strings.Fields(c.Text) {
+			if f == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestChunkerHeadingPaths(t *testing.T) {
+	useWordCounter(t)
+	ck := &Chunker{MaxTokens: 50, Overlap: 5}
+
+	pages := []PageData{
+		{Page: 1, Text: "Intro before any heading.\n**Graphs**\nVertices and edges.\n*Traversal*\nBFS and DFS."},
+		{Page: 2, Text: "More on DFS.\n## Trees\nA tree has no cycles.\n# Heaps\nA heap is a tree."},
+	}
+	want := []struct {
+		path       []string
+		start, end int
+	}{
+		{nil, 1, 1},
+		{[]string{"Graphs"}, 1, 1},
+		{[]string{"Graphs", "Traversal"}, 1, 2},
+		{[]string{"Graphs", "Trees"}, 2, 2},
+		{[]string{"Heaps"}, 2, 2},
+	}
+
+	chunks := ck.Split(pages)
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i, c := range chunks {
+		if !reflect.DeepEqual(c.HeadingPath, want[i].path) || c.PageStart != want[i].start || c.PageEnd != want[i].end {
+			t.Errorf("chunk %d = %q on pages %d-%d, want %q on pages %d-%d",
+				i, c.HeadingPath, c.PageStart, c.PageEnd, want[i].path, want[i].start, want[i].end)
+		}
+	}
+}
+
+func TestChunkerTiming(t *testing.T) {
+	useWordCounter(t)
+
+	type span struct{ start, end float64 }
+	tests := []struct {
+		name  string
+		pages []PageData
+		want  []span
+	}{
+		{
+			name:  "markers",
+			pages: []PageData{{Page: 1, Text: "[0:00] One two. [0:30] Three four.\n[1:00] Five six.", End: 90}},
+			want:  []span{{0, 30}, {30, 60}, {60, 90}},
+		},
+		{
+			name:  "sentences without a marker start with the previous one",
+			pages: []PageData{{Page: 1, Text: "[0:10] One two. Three four. [0:40] Five six.", End: 60}},
+			want:  []span{{10, 10}, {10, 40}, {40, 60}},
+		},
+		{
+			name:  "misplaced marker",
+			pages: []PageData{{Page: 1, Text: "[1:00] One two. [0:30] Three four. [1:30] Five six.", Start: 50, End: 120}},
+			want:  []span{{60, 60}, {60, 90}, {90, 120}},
+		},
+		{
+			name:  "hours",
+			pages: []PageData{{Page: 1, Text: "[1:02:03] One two.", Start: 3700, End: 3800}},
+			want:  []span{{3723, 3800}},
+		},
+		{
+			name: "sections",
+			pages: []PageData{
+				{Page: 1, Text: "[0:05] One two.", Start: 0, End: 30},
+				{Page: 2, Text: "Three four. [0:45] Five six.", Start: 30, End: 60},
+			},
+			want: []span{{5, 30}, {30, 45}, {45, 60}},
+		},
+	}
+
+	ck := &Chunker{MaxTokens: 4}
+	for _, tt := range tests {
+		chunks := ck.Split(tt.pages)
+		var got []span
+		for _, c := range chunks {
+			if !c.IsTimed() {
+				t.Errorf("%s: chunk %q is not timed", tt.name, c.Text)
+			}
+			got = append(got, span{c.TimeStart, c.TimeEnd})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: chunk times = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChunkerHeadingTakesTheTimeOfItsText(t *testing.T) {
+	useWordCounter(t)
+	ck := &Chunker{MaxTokens: 20}
+
+	chunks := ck.Split([]PageData{{Page: 1, Text: "[0:00] Hello all.\n**Graphs**\n[0:40] A graph has edges.", End: 80}})
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(chunks))
+	}
+	if c := chunks[1]; c.TimeStart != 40 || c.TimeEnd != 80 || !strings.HasPrefix(c.Text, "**Graphs**") {
+		t.Errorf("section chunk = %q at %v-%v, want the heading at 40-80", c.Text, c.TimeStart, c.TimeEnd)
+	}
+	if c := chunks[0]; c.TimeEnd != 40 {
+		t.Errorf("intro chunk ends at %v, want 40", c.TimeEnd)
+	}
+}
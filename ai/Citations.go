@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Answer is the reply to a question, with the chunks it was drawn from
+type Answer struct {
+	Text      string     `json:"text"`
+	Citations []Citation `json:"citations"`
+}
+
+// Citation points at a chunk an answer cites. Number matches the [n] marker in the answer text.
+type Citation struct {
+	Number    int    `json:"number"`
+	ChunkID   string `json:"chunk_id"`
+	DatasetID int    `json:"dataset_id"`
+	Filename  string `json:"filename"`
+	Page      int    `json:"page,omitempty"`      // documents
+	Timestamp string `json:"timestamp,omitempty"` // recordings, e.g. "23:10"
+	Snippet   string `json:"snippet"`
+}
+
+// citationMarker matches the chunk citations the QA prompt asks for, e.g. [chunk:12-3]
+// or [chunk:12-3, chunk:12-4]
+var citationMarker = regexp.MustCompile(`\[\s*chunk:[^\]]*\]`)
+
+// citedID matches one chunk ID inside a citation marker
+var citedID = regexp.MustCompile(`chunk:\s*([\w-]+)`)
+
+// snippetChars is the length of the chunk excerpt returned with a citation
+const snippetChars = 240
+
+// citeAnswer replaces the chunk citations in the LLM's reply with numbered markers and
+// resolves them against the chunks that were in the context. IDs that weren't in the
+// context are made up by the model and dropped.
+func citeAnswer(reply string, chunks []ScoredChunk) *Answer {
+	byID := make(map[string]Chunk, len(chunks))
+	for _, c := range chunks {
+		byID[c.ChunkID] = c.Chunk
+	}
+
+	answer := &Answer{Citations: []Citation{}}
+	numbers := map[string]int{}
+	var invalid []string
+	var sb strings.Builder
+	last := 0
+	for _, loc := range citationMarker.FindAllStringIndex(reply, -1) {
+		var refs []string
+		for _, m := range citedID.FindAllStringSubmatch(reply[loc[0]:loc[1]], -1) {
+			id := m[1]
+			c, ok := byID[id]
+			if !ok {
+				invalid = append(invalid, id)
+				continue
+			}
+
+			n, ok := numbers[id]
+			if !ok {
+				n = len(answer.Citations) + 1
+				numbers[id] = n
+				answer.Citations = append(answer.Citations, newCitation(n, c))
+			}
+			refs = append(refs, fmt.Sprintf("[%d]", n))
+		}
+
+		before, end := reply[last:loc[0]], loc[1]
+		if len(refs) == 0 {
+			// A dropped marker takes the spaces before it along, or the spaces after it
+			// when it starts a line, so no stray space is left in the sentence
+			trimmed := strings.TrimRight(before, " \t")
+			written := sb.String() + trimmed
+			if written != "" && !strings.HasSuffix(written, "\n") {
+				before = trimmed
+			} else {
+				for end < len(reply) && (reply[end] == ' ' || reply[end] == '\t') {
+					end++
+				}
+			}
+		}
+		sb.WriteString(before)
+		sb.WriteString(strings.Join(refs, ""))
+		last = end
+	}
+	sb.WriteString(reply[last:])
+	if len(invalid) > 0 {
+		fmt.Println("Dropped citations of chunks not in the context:", invalid)
+	}
+
+	answer.Text = strings.TrimSpace(sb.String())
+	return answer
+}
+
+// newCitation describes where a chunk comes from
+func newCitation(n int, c Chunk) Citation {
+	cite := Citation{
+		Number:    n,
+		ChunkID:   c.ChunkID,
+		DatasetID: c.DatasetID,
+		Filename:  c.Source,
+		Snippet:   snippet(c.Text),
+	}
+	if c.IsTimed() {
+		cite.Timestamp = formatTimestamp(c.TimeStart)
+	} else {
+		cite.Page = c.PageStart
+	}
+	return cite
+}
+
+// snippet returns the start of a chunk's text on one line, cut at a word boundary
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= snippetChars {
+		return text
+	}
+
+	cut := string([]rune(text)[:snippetChars])
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + " …"
+}
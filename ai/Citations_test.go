@@ -0,0 +1,86 @@
+package ai
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCiteAnswer(t *testing.T) {
+	chunks := []ScoredChunk{
+		{Chunk: Chunk{ChunkID: "3-1", DatasetID: 3, Source: "notes.pdf", PageStart: 2, PageEnd: 2, Text: "Dijkstra's algorithm finds shortest paths."}},
+		{Chunk: Chunk{ChunkID: "5-4", DatasetID: 5, Source: "lecture.mp4", TimeStart: 1390, TimeEnd: 1420, Text: "Negative edges break it."}},
+	}
+	pdf := Citation{Number: 1, ChunkID: "3-1", DatasetID: 3, Filename: "notes.pdf", Page: 2, Snippet: "Dijkstra's algorithm finds shortest paths."}
+	video := Citation{ChunkID: "5-4", DatasetID: 5, Filename: "lecture.mp4", Timestamp: "23:10", Snippet: "Negative edges break it."}
+
+	tests := []struct {
+		name  string
+		reply string
+		text  string
+		cites []Citation
+	}{
+		{
+			name:  "numbered in order of first use",
+			reply: "It finds shortest paths [chunk:3-1]. It fails with negative edges [chunk:5-4], see [chunk:3-1].",
+			text:  "It finds shortest paths [1]. It fails with negative edges [2], see [1].",
+			cites: []Citation{pdf, withNumber(video, 2)},
+		},
+		{
+			name:  "several IDs in one marker",
+			reply: "Both apply [chunk:5-4, chunk: 3-1].",
+			text:  "Both apply [1][2].",
+			cites: []Citation{withNumber(video, 1), withNumber(pdf, 2)},
+		},
+		{
+			name:  "made up IDs are dropped",
+			reply: "It is greedy [chunk:9-9]. It finds shortest paths [chunk:3-1, chunk:1-1].",
+			text:  "It is greedy. It finds shortest paths [1].",
+			cites: []Citation{pdf},
+		},
+		{
+			name:  "dropped markers at the start of a line and side by side",
+			reply: "[chunk:9-9] It is greedy [chunk:9-8] [chunk:9-7].\nSee [chunk:3-1].",
+			text:  "It is greedy.\nSee [1].",
+			cites: []Citation{pdf},
+		},
+		{
+			name:  "code and spacing outside markers are kept",
+			reply: "Relax each edge [chunk:3-1]:\n\n```go\nfor _, e := range edges {\n    if d[e.to] > d[e.from]  +  e.w {\n        d[e.to] = x .5 // a ,b\n    }\n}\n```\n\n  - nested  item [chunk:9-9]",
+			text:  "Relax each edge [1]:\n\n```go\nfor _, e := range edges {\n    if d[e.to] > d[e.from]  +  e.w {\n        d[e.to] = x .5 // a ,b\n    }\n}\n```\n\n  - nested  item",
+			cites: []Citation{pdf},
+		},
+		{
+			name:  "no citations",
+			reply: "  I don't know.  ",
+			text:  "I don't know.",
+			cites: []Citation{},
+		},
+	}
+
+	for _, tt := range tests {
+		got := citeAnswer(tt.reply, chunks)
+		if got.Text != tt.text {
+			t.Errorf("%s: text = %q, want %q", tt.name, got.Text, tt.text)
+		}
+		if !reflect.DeepEqual(got.Citations, tt.cites) {
+			t.Errorf("%s: citations = %+v, want %+v", tt.name, got.Citations, tt.cites)
+		}
+	}
+}
+
+func withNumber(c Citation, n int) Citation {
+	c.Number = n
+	return c
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	got := snippet(long)
+	if !strings.HasSuffix(got, " …") || len([]rune(got)) > snippetChars+2 || strings.Contains(got, "wor …") {
+		t.Errorf("snippet = %q, want the text cut at a word boundary", got)
+	}
+	if got := snippet("Two\n  lines"); got != "Two lines" {
+		t.Errorf("snippet = %q, want the text on one line", got)
+	}
+}
@@ -0,0 +1,31 @@
+package ai
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envOr returns the environment variable key, or def when it is unset or blank.
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns the environment variable key as an int, or def when it is unset or invalid.
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(envOr(key, "")); err == nil {
+		return v
+	}
+	return def
+}
+
+// envFloat returns the environment variable key as a float64, or def when it is unset or invalid.
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(envOr(key, ""), 64); err == nil {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,103 @@
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ContextBuilder assembles the context passed to the LLM from retrieved chunks: the
+// most relevant first, each distinct text once, within a token budget
+type ContextBuilder struct {
+	Budget int
+	// MinTokens is the smallest remainder of the budget worth filling with a
+	// truncated chunk; smaller remainders are left empty
+	MinTokens int
+}
+
+// newContextBuilder builds a context builder from CONTEXT_TOKEN_BUDGET
+func newContextBuilder() *ContextBuilder {
+	return &ContextBuilder{Budget: max(envInt("CONTEXT_TOKEN_BUDGET", 6000), 1), MinTokens: 64}
+}
+
+// BuiltContext is the assembled context and what was left out of it
+type BuiltContext struct {
+	Text       string
+	Tokens     int
+	Chunks     []ScoredChunk // chunks in the context, in order
+	Truncated  []string      // IDs of chunks cut short to fit the budget
+	Dropped    []string      // IDs of chunks left out because the budget was spent
+	Duplicates int           // chunks skipped because their text was already included
+}
+
+// Report summarizes what the budget cut, empty if nothing was cut
+func (b *BuiltContext) Report() string {
+	if len(b.Truncated) == 0 && len(b.Dropped) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("context uses %d tokens with %d chunks: %d truncated %v, %d dropped %v",
+		b.Tokens, len(b.Chunks), len(b.Truncated), b.Truncated, len(b.Dropped), b.Dropped)
+}
+
+// Build ranks the candidates by score and fills the budget with them
+func (cb *ContextBuilder) Build(candidates []ScoredChunk) *BuiltContext {
+	ranked := append([]ScoredChunk{}, candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	built := &BuiltContext{}
+	seen := map[string]bool{}
+	var blocks []string
+	for _, c := range ranked {
+		key := c.Hash
+		if key == "" {
+			key = hashText(c.Text)
+		}
+		if seen[key] {
+			built.Duplicates++
+			continue
+		}
+		seen[key] = true
+
+		header := contextHeader(c.Chunk)
+		block := header + "\n" + c.Text
+		tokens := estimateTokens(block) + 1 // blank line between blocks
+		remaining := cb.Budget - built.Tokens
+
+		if tokens > remaining {
+			text := ""
+			if remaining >= cb.MinTokens {
+				text = truncateTokens(c.Text, remaining-estimateTokens(header)-2)
+			}
+			if text == "" {
+				built.Dropped = append(built.Dropped, c.ChunkID)
+				continue
+			}
+			block = header + "\n" + text
+			tokens = estimateTokens(block) + 1
+			built.Truncated = append(built.Truncated, c.ChunkID)
+		}
+
+		blocks = append(blocks, block)
+		built.Chunks = append(built.Chunks, c)
+		built.Tokens += tokens
+	}
+
+	built.Text = strings.Join(blocks, "\n\n")
+	return built
+}
+
+// contextHeader tells the LLM which chunk follows and where it comes from,
+// e.g. "[chunk:12-3 | notes.pdf, p. 3 > Entropy]"
+func contextHeader(c Chunk) string {
+	parts := []string{c.Source}
+	if loc := c.Location(); loc != "" {
+		parts = append(parts, loc)
+	}
+	header := "chunk:" + c.ChunkID + " | " + strings.Join(parts, ", ")
+	if len(c.HeadingPath) > 0 {
+		header += " > " + strings.Join(c.HeadingPath, " > ")
+	}
+	return "[" + header + "]"
+}
@@ -0,0 +1,376 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps each user's chunks in <Root>/<user id>/dataset.jsonl, with the
+// version of the chunks in dataset.version and the BM25 index in bm25.json. Writes are
+// serialized per user, by a mutex within the process and a lock file across
+// processes, and replace the file atomically so a crash never leaves it truncated.
+type FileStore struct {
+	Root string
+
+	mu    sync.Mutex
+	users map[int]*sync.Mutex
+}
+
+// path returns the dataset file of a user
+func (s *FileStore) path(userID int) string {
+	return s.userFile(userID, "dataset.jsonl")
+}
+
+// userFile returns a file of the user next to their dataset
+func (s *FileStore) userFile(userID int, name string) string {
+	return filepath.Join(s.Root, strconv.Itoa(userID), name)
+}
+
+// lock takes the user's dataset lock and returns the func releasing it
+func (s *FileStore) lock(userID int) (func(), error) {
+	s.mu.Lock()
+	if s.users == nil {
+		s.users = map[int]*sync.Mutex{}
+	}
+	mu, ok := s.users[userID]
+	if !ok {
+		mu = &sync.Mutex{}
+		s.users[userID] = mu
+	}
+	s.mu.Unlock()
+
+	mu.Lock()
+	path := s.path(userID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		mu.Unlock()
+		return nil, fmt.Errorf("error locking dataset: %v", err)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+		mu.Unlock()
+	}, nil
+}
+
+// load reads the user's dataset file, recovering it if it is corrupt
+func (s *FileStore) load(userID int) ([]Chunk, error) {
+	path := s.path(userID)
+	chunks, err := readChunksFile(path)
+	if corrupt, ok := err.(*CorruptDatasetError); ok {
+		return chunks, recoverDatasetFile(path, chunks, corrupt)
+	}
+	return chunks, err
+}
+
+func (s *FileStore) ReplaceDataset(ctx context.Context, userID, datasetID int, chunks []Chunk) error {
+	unlock, err := s.lock(userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := s.load(userID)
+	if err != nil {
+		return err
+	}
+
+	var kept []Chunk
+	for _, c := range existing {
+		if c.DatasetID != datasetID {
+			kept = append(kept, c)
+		}
+	}
+
+	data, err := encodeChunks(append(kept, chunks...))
+	if err != nil {
+		return err
+	}
+	// The version goes first: if the dataset write fails, the index is only rebuilt needlessly
+	if err := s.bumpVersion(userID); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(userID), data)
+}
+
+func (s *FileStore) LoadUser(ctx context.Context, userID int) ([]Chunk, error) {
+	if _, err := os.Stat(s.path(userID)); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	unlock, err := s.lock(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.load(userID)
+}
+
+func (s *FileStore) DeleteDataset(ctx context.Context, userID, datasetID int) error {
+	if _, err := os.Stat(s.path(userID)); os.IsNotExist(err) {
+		return nil
+	}
+	return s.ReplaceDataset(ctx, userID, datasetID, nil)
+}
+
+func (s *FileStore) UpdateEmbeddings(ctx context.Context, userID int, chunks []Chunk) error {
+	unlock, err := s.lock(userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := s.load(userID)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]Chunk, len(chunks))
+	for _, c := range chunks {
+		byID[c.ChunkID] = c
+	}
+	for i, c := range existing {
+		if fresh, ok := byID[c.ChunkID]; ok && fresh.Hash == c.Hash {
+			existing[i].Embedding, existing[i].EmbeddingModel = fresh.Embedding, fresh.EmbeddingModel
+		}
+	}
+
+	data, err := encodeChunks(existing)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(userID), data)
+}
+
+// version reads the version of the user's chunks. Datasets written before versions
+// were recorded count as version 1.
+func (s *FileStore) version(userID int) (int64, error) {
+	data, err := os.ReadFile(s.userFile(userID, "dataset.version"))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(s.path(userID)); os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// bumpVersion records a change to the user's chunks; the caller holds the user's lock
+func (s *FileStore) bumpVersion(userID int) error {
+	version, err := s.version(userID)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.userFile(userID, "dataset.version"), []byte(strconv.FormatInt(version+1, 10)))
+}
+
+func (s *FileStore) Version(ctx context.Context, userID int) (int64, error) {
+	if _, err := os.Stat(s.path(userID)); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	unlock, err := s.lock(userID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return s.version(userID)
+}
+
+func (s *FileStore) LoadChunks(ctx context.Context, userID int, chunkIDs []string) ([]Chunk, error) {
+	all, err := s.LoadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(chunkIDs))
+	for _, id := range chunkIDs {
+		wanted[id] = true
+	}
+	var chunks []Chunk
+	for _, c := range all {
+		if wanted[c.ChunkID] {
+			chunks = append(chunks, c)
+		}
+	}
+	return chunks, nil
+}
+
+func (s *FileStore) LoadSearchIndex(ctx context.Context, userID int) ([]byte, error) {
+	data, err := os.ReadFile(s.userFile(userID, "bm25.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *FileStore) SaveSearchIndex(ctx context.Context, userID int, version int64, data []byte) error {
+	unlock, err := s.lock(userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// An index built from older chunks never replaces a newer one
+	saved, err := os.ReadFile(s.userFile(userID, "bm25.version"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if v, err := strconv.ParseInt(strings.TrimSpace(string(saved)), 10, 64); err == nil && v > version {
+			return nil
+		}
+	}
+
+	if err := writeFileAtomic(s.userFile(userID, "bm25.json"), data); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.userFile(userID, "bm25.version"), []byte(strconv.FormatInt(version, 10)))
+}
+
+// CorruptDatasetError is returned when a dataset file cannot be parsed in full
+type CorruptDatasetError struct {
+	Path  string
+	Lines []int // lines that could not be parsed, empty if the whole file is unreadable
+	Err   error
+}
+
+func (e *CorruptDatasetError) Error() string {
+	if len(e.Lines) > 0 {
+		return fmt.Sprintf("corrupt dataset %s: %d unreadable lines (first at line %d): %v", e.Path, len(e.Lines), e.Lines[0], e.Err)
+	}
+	return fmt.Sprintf("corrupt dataset %s: %v", e.Path, e.Err)
+}
+
+// readChunksFile reads a dataset file. Files in the legacy topic format are converted
+// on the fly; use MigrateDatasetFile to rewrite them on disk. If some lines cannot be
+// parsed, the chunks of the other lines are returned with a *CorruptDatasetError.
+func readChunksFile(path string) ([]Chunk, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading dataset: %v", err)
+	}
+
+	if isLegacyDataset(data) {
+		chunks, err := migrateLegacyDataset(data)
+		if err != nil {
+			return nil, &CorruptDatasetError{Path: path, Err: err}
+		}
+		return chunks, nil
+	}
+
+	var chunks []Chunk
+	var corrupt *CorruptDatasetError
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var c Chunk
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			if corrupt == nil {
+				corrupt = &CorruptDatasetError{Path: path, Err: err}
+			}
+			corrupt.Lines = append(corrupt.Lines, line)
+			continue
+		}
+		if c.Version > ChunkSchemaVersion {
+			return nil, fmt.Errorf("dataset line %d uses chunk schema v%d, newer than this server (v%d)", line, c.Version, ChunkSchemaVersion)
+		}
+		chunks = append(chunks, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading dataset: %v", err)
+	}
+	if corrupt != nil {
+		return chunks, corrupt
+	}
+	return chunks, nil
+}
+
+// recoverDatasetFile moves a corrupt dataset file aside and replaces it with the chunks
+// that could still be read, so one bad write doesn't lock the user out of their dataset
+func recoverDatasetFile(path string, chunks []Chunk, corrupt *CorruptDatasetError) error {
+	fmt.Println("Recovering dataset:", corrupt)
+
+	backup := fmt.Sprintf("%s.corrupt-%d", path, time.Now().UnixNano())
+	if err := os.Rename(path, backup); err != nil {
+		return err
+	}
+
+	data, err := encodeChunks(chunks)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	fmt.Printf("Kept %d chunks of %s, the corrupt file is saved as %s\n", len(chunks), path, backup)
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it over
+// path, so readers see either the old or the new content and never a partial write
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
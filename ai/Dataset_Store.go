@@ -0,0 +1,447 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/edubank/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// DatasetStore keeps the chunks extracted from each user's datasets
+type DatasetStore interface {
+	// ReplaceDataset stores the chunks of a dataset, replacing those of any earlier ingestion
+	ReplaceDataset(ctx context.Context, userID, datasetID int, chunks []Chunk) error
+	// LoadUser returns the chunks of all datasets of a user
+	LoadUser(ctx context.Context, userID int) ([]Chunk, error)
+	// DeleteDataset removes the chunks of a dataset
+	DeleteDataset(ctx context.Context, userID, datasetID int) error
+	// UpdateEmbeddings stores the embeddings of chunks embedded again, e.g. by a new model.
+	// Chunks are matched by ID and hash, so embeddings of text that changed meanwhile are dropped.
+	UpdateEmbeddings(ctx context.Context, userID int, chunks []Chunk) error
+
+	// Version returns a number that changes whenever the text of the user's chunks changes
+	Version(ctx context.Context, userID int) (int64, error)
+	// LoadChunks returns the user's chunks with the given IDs, in no particular order
+	LoadChunks(ctx context.Context, userID int, chunkIDs []string) ([]Chunk, error)
+	// LoadSearchIndex returns the user's saved BM25 index, nil if there is none
+	LoadSearchIndex(ctx context.Context, userID int) ([]byte, error)
+	// SaveSearchIndex saves the user's BM25 index, built from the chunks at version
+	SaveSearchIndex(ctx context.Context, userID int, version int64, data []byte) error
+}
+
+// ErrDatasetNotFound is returned when a dataset does not exist or belongs to another user
+var ErrDatasetNotFound = errors.New("dataset not found")
+
+var (
+	storeMu sync.Mutex
+	store   DatasetStore
+)
+
+// SetDatasetStore overrides the store used by the package (e.g. in tests)
+func SetDatasetStore(s DatasetStore) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+// datasetStore returns the configured store, building it from the environment on first use
+func datasetStore() (DatasetStore, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store != nil {
+		return store, nil
+	}
+
+	s, err := NewDatasetStore()
+	if err != nil {
+		return nil, err
+	}
+	store = s
+	return store, nil
+}
+
+// NewDatasetStore builds a store from DATASET_STORE ("postgres" or "file")
+func NewDatasetStore() (DatasetStore, error) {
+	switch name := envOr("DATASET_STORE", "postgres"); name {
+	case "postgres":
+		return &PostgresStore{Pgvector: envOr("VECTOR_STORE", "memory") == "pgvector"}, nil
+	case "file":
+		return &FileStore{Root: envOr("DATASET_DIR", "ai/users")}, nil
+	default:
+		return nil, fmt.Errorf("unknown DATASET_STORE: %s", name)
+	}
+}
+
+// DeleteDataset removes the chunks of a dataset from the configured store and the user's search index
+func DeleteDataset(ctx context.Context, userID, datasetID int) error {
+	s, err := datasetStore()
+	if err != nil {
+		return err
+	}
+	if err := s.DeleteDataset(ctx, userID, datasetID); err != nil {
+		return err
+	}
+	return updateBM25Index(ctx, s, userID)
+}
+
+// PostgresStore keeps chunks in the dataset_chunks table, linked to their datasets row
+type PostgresStore struct {
+	// Pgvector mirrors the embeddings into the embedding_vec column searched by
+	// PgvectorRetriever; only then is the pgvector extension (migration 007) needed
+	Pgvector bool
+}
+
+func (s *PostgresStore) ReplaceDataset(ctx context.Context, userID, datasetID int, chunks []Chunk) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the dataset row serializes concurrent ingestions of the same dataset
+	var id int
+	err = tx.QueryRow(ctx, "SELECT id FROM datasets WHERE id=$1 AND user_id=$2 FOR UPDATE", datasetID, userID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrDatasetNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM dataset_chunks WHERE dataset_id=$1", datasetID); err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"dataset_chunks"},
+		[]string{"dataset_id", "chunk_id", "seq", "version", "page_start", "page_end", "time_start", "time_end", "heading_path", "text", "hash", "embedding", "embedding_model"},
+		pgx.CopyFromRows(chunkRows(datasetID, chunks)))
+	if err != nil {
+		return err
+	}
+	if err := bumpVersion(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if s.Pgvector {
+		_, err = tx.Exec(ctx,
+			"UPDATE dataset_chunks SET embedding_vec = embedding::vector WHERE dataset_id=$1 AND cardinality(embedding) = $2",
+			datasetID, pgvectorDims)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// chunkRows lays out chunks as dataset_chunks rows for CopyFrom. pgx writes a nil
+// slice as NULL, which heading_path doesn't allow, so chunks without headings get
+// an empty path.
+func chunkRows(datasetID int, chunks []Chunk) [][]interface{} {
+	rows := make([][]interface{}, len(chunks))
+	for i, c := range chunks {
+		path := c.HeadingPath
+		if path == nil {
+			path = []string{}
+		}
+		rows[i] = []interface{}{datasetID, c.ChunkID, i, c.Version, c.PageStart, c.PageEnd, c.TimeStart, c.TimeEnd, path, c.Text, c.Hash, c.Embedding, c.EmbeddingModel}
+	}
+	return rows
+}
+
+func (s *PostgresStore) UpdateEmbeddings(ctx context.Context, userID int, chunks []Chunk) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	ids := make([]string, len(chunks))
+	for i, c := range chunks {
+		ids[i] = c.ChunkID
+		batch.Queue(
+			"UPDATE dataset_chunks c SET embedding=$1, embedding_model=$2 FROM datasets d "+
+				"WHERE d.id = c.dataset_id AND d.user_id=$3 AND c.chunk_id=$4 AND c.hash=$5",
+			c.Embedding, c.EmbeddingModel, userID, c.ChunkID, c.Hash)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	if s.Pgvector {
+		// Vectors of the previous model must not be searched as if they came from the new one
+		_, err = tx.Exec(ctx,
+			"UPDATE dataset_chunks SET embedding_vec = CASE WHEN cardinality(embedding) = $2 THEN embedding::vector END "+
+				"WHERE chunk_id = ANY($1)",
+			ids, pgvectorDims)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// backfillVectors mirrors the embeddings of chunks stored while pgvector was off
+func (s *PostgresStore) backfillVectors(ctx context.Context) error {
+	tag, err := db.Pool.Exec(ctx,
+		"UPDATE dataset_chunks SET embedding_vec = embedding::vector WHERE embedding_vec IS NULL AND cardinality(embedding) = $1",
+		pgvectorDims)
+	if err == nil && tag.RowsAffected() > 0 {
+		fmt.Printf("Filled in the pgvector embeddings of %d chunks\n", tag.RowsAffected())
+	}
+	return err
+}
+
+// chunkColumns are the columns of a Chunk, selected from dataset_chunks c joined with datasets d
+const chunkColumns = "c.version, c.dataset_id, c.chunk_id, d.filename, c.page_start, c.page_end, c.time_start, c.time_end, c.heading_path, c.text, c.hash, c.embedding, c.embedding_model"
+
+// chunkFields returns the scan targets for chunkColumns
+func chunkFields(c *Chunk) []interface{} {
+	return []interface{}{&c.Version, &c.DatasetID, &c.ChunkID, &c.Source, &c.PageStart, &c.PageEnd,
+		&c.TimeStart, &c.TimeEnd, &c.HeadingPath, &c.Text, &c.Hash, &c.Embedding, &c.EmbeddingModel}
+}
+
+func (s *PostgresStore) LoadUser(ctx context.Context, userID int) ([]Chunk, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+chunkColumns+" FROM dataset_chunks c JOIN datasets d ON d.id = c.dataset_id "+
+			"WHERE d.user_id=$1 ORDER BY c.dataset_id, c.seq", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []Chunk
+	for rows.Next() {
+		var c Chunk
+		if err := rows.Scan(chunkFields(&c)...); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func (s *PostgresStore) DeleteDataset(ctx context.Context, userID, datasetID int) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		"DELETE FROM dataset_chunks c USING datasets d WHERE d.id = c.dataset_id AND c.dataset_id=$1 AND d.user_id=$2",
+		datasetID, userID)
+	if err != nil {
+		return err
+	}
+	if err := bumpVersion(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// bumpVersion records a change to the user's chunks, invalidating their search index
+func bumpVersion(ctx context.Context, tx pgx.Tx, userID int) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO search_indexes (user_id, chunks_version) VALUES ($1, 1) "+
+			"ON CONFLICT (user_id) DO UPDATE SET chunks_version = search_indexes.chunks_version + 1",
+		userID)
+	return err
+}
+
+func (s *PostgresStore) Version(ctx context.Context, userID int) (int64, error) {
+	var version int64
+	err := db.Pool.QueryRow(ctx, "SELECT chunks_version FROM search_indexes WHERE user_id=$1", userID).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (s *PostgresStore) LoadChunks(ctx context.Context, userID int, chunkIDs []string) ([]Chunk, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+chunkColumns+" FROM dataset_chunks c JOIN datasets d ON d.id = c.dataset_id "+
+			"WHERE d.user_id=$1 AND c.chunk_id = ANY($2)", userID, chunkIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []Chunk
+	for rows.Next() {
+		var c Chunk
+		if err := rows.Scan(chunkFields(&c)...); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func (s *PostgresStore) LoadSearchIndex(ctx context.Context, userID int) ([]byte, error) {
+	var data []byte
+	err := db.Pool.QueryRow(ctx, "SELECT bm25 FROM search_indexes WHERE user_id=$1", userID).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *PostgresStore) SaveSearchIndex(ctx context.Context, userID int, version int64, data []byte) error {
+	// An index built from older chunks never replaces a newer one
+	_, err := db.Pool.Exec(ctx,
+		"INSERT INTO search_indexes (user_id, bm25_version, bm25) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (user_id) DO UPDATE SET bm25_version = excluded.bm25_version, bm25 = excluded.bm25 "+
+			"WHERE search_indexes.bm25_version <= excluded.bm25_version",
+		userID, version, data)
+	return err
+}
+
+// ImportDatasets moves the dataset.jsonl files under root into the configured store.
+// With the file store, legacy files are only converted to chunks. Chunks that cannot be
+// linked to a dataset (those of legacy files, which don't record their dataset, and
+// those of deleted datasets) stay in dataset.jsonl; the datasets they came from are
+// re-ingested from their uploads (see DatasetsWithoutChunks). Fully imported files
+// are renamed to dataset.jsonl.imported. With pgvector, the vectors of chunks ingested
+// while it was off are filled in first.
+func ImportDatasets(ctx context.Context, root string) error {
+	s, err := datasetStore()
+	if err != nil {
+		return err
+	}
+	if _, ok := s.(*FileStore); ok {
+		return MigrateDatasets(root)
+	}
+	if pg, ok := s.(*PostgresStore); ok && pg.Pgvector {
+		if err := pg.backfillVectors(ctx); err != nil {
+			return fmt.Errorf("error filling embedding_vec: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		userID, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(root, e.Name(), "dataset.jsonl")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := importDatasetFile(ctx, s, userID, path); err != nil {
+			return fmt.Errorf("importing %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// importDatasetFile stores the chunks of one user's dataset file and renames the file
+func importDatasetFile(ctx context.Context, s DatasetStore, userID int, path string) error {
+	chunks, err := readChunksFile(path)
+	if corrupt, ok := err.(*CorruptDatasetError); ok {
+		fmt.Println("Importing the readable part of", corrupt)
+	} else if err != nil {
+		return err
+	}
+
+	var order []int
+	byDataset := map[int][]Chunk{}
+	for _, c := range chunks {
+		if _, ok := byDataset[c.DatasetID]; !ok {
+			order = append(order, c.DatasetID)
+		}
+		byDataset[c.DatasetID] = append(byDataset[c.DatasetID], c)
+	}
+
+	var imported, skipped []Chunk
+	for _, datasetID := range order {
+		group := byDataset[datasetID]
+		if datasetID == 0 {
+			skipped = append(skipped, group...)
+			continue
+		}
+
+		err := s.ReplaceDataset(ctx, userID, datasetID, group)
+		if errors.Is(err, ErrDatasetNotFound) {
+			skipped = append(skipped, group...)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		imported = append(imported, group...)
+	}
+
+	fmt.Printf("Imported %d chunks from %s (%d without a dataset kept)\n", len(imported), path, len(skipped))
+	if len(imported) == 0 {
+		// Nothing changed, so leave the file as it is rather than replacing the backup
+		return nil
+	}
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return err
+	}
+	if len(skipped) == 0 {
+		return nil
+	}
+
+	// Keep the chunks that couldn't be imported, so no content is dropped
+	out, err := encodeChunks(skipped)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, out)
+}
+
+// UnindexedDataset is an uploaded dataset without chunks in the store
+type UnindexedDataset struct {
+	ID       int
+	UserID   int
+	FilePath string
+}
+
+// DatasetsWithoutChunks lists the datasets the Postgres store holds no chunks for, e.g.
+// those only found in legacy dataset files. It returns nothing with other stores, which
+// don't share the datasets table.
+func DatasetsWithoutChunks(ctx context.Context) ([]UnindexedDataset, error) {
+	s, err := datasetStore()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.(*PostgresStore); !ok {
+		return nil, nil
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		"SELECT d.id, d.user_id, d.file_url FROM datasets d "+
+			"WHERE NOT EXISTS (SELECT 1 FROM dataset_chunks c WHERE c.dataset_id = d.id) ORDER BY d.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var datasets []UnindexedDataset
+	for rows.Next() {
+		var d UnindexedDataset
+		if err := rows.Scan(&d.ID, &d.UserID, &d.FilePath); err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, d)
+	}
+	return datasets, rows.Err()
+}
@@ -0,0 +1,17 @@
+package ai
+
+import "testing"
+
+func TestChunkRowsHaveNoNullHeadingPath(t *testing.T) {
+	// Image text has no headings, so the chunker leaves the path nil
+	chunks := buildChunks(Source{DatasetID: 2, Filename: "scan.png"}, nil, "Text read from an image.")
+	chunks = append(chunks, Chunk{ChunkID: "2-9", HeadingPath: []string{"Graphs"}, Text: "Under a heading."})
+
+	const headingCol = 8
+	for i, row := range chunkRows(2, chunks) {
+		path, ok := row[headingCol].([]string)
+		if !ok || path == nil {
+			t.Errorf("row %d heading_path = %#v, want a non-nil []string", i, row[headingCol])
+		}
+	}
+}
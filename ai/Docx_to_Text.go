@@ -0,0 +1,144 @@
+package ai
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DocxToText extracts the text of a Word document page by page. Pages follow the
+// page breaks stored in the file; headings are marked with double/single stars like
+// the LLM-cleaned PDF text.
+func DocxToText(ctx context.Context, docxFilePath string) ([]PageData, error) {
+	fmt.Println("Extracting text from the Word document")
+	Emit(ctx, Event{Stage: StageConverting})
+
+	zr, err := zip.OpenReader(docxFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening docx: %v", err)
+	}
+	defer zr.Close()
+
+	document, err := readZipPart(&zr.Reader, "word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := parseDocxDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("no text found")
+	}
+
+	reportProgress(ctx, len(pages), len(pages))
+	return pages, nil
+}
+
+// parseDocxDocument walks word/document.xml, splitting the text at page breaks
+func parseDocxDocument(document []byte) ([]PageData, error) {
+	var pages []PageData
+	var page, para strings.Builder
+	style := ""
+	inText := false
+	pageNo := 1
+	// atBreak is set from a page break until the next text, so the lastRenderedPageBreak
+	// Word stores right after an explicit break isn't counted as another page
+	atBreak := false
+
+	// breakPage ends the current page at a page break. Pages without text are left
+	// out but keep their number, so the pages after them are numbered as in Word.
+	breakPage := func(rendered bool) {
+		if rendered && atBreak {
+			return
+		}
+		if text := strings.TrimSpace(para.String()); text != "" {
+			// A paragraph split by the break keeps its heading mark on this page
+			page.WriteString(docxHeading(style, text))
+			page.WriteString("\n")
+		}
+		para.Reset()
+		if text := strings.TrimSpace(page.String()); text != "" {
+			pages = append(pages, PageData{Page: pageNo, Text: text})
+		}
+		page.Reset()
+		pageNo++
+		atBreak = true
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing docx: %v", err)
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "p":
+				para.Reset()
+				style = ""
+			case "pStyle":
+				style = xmlAttr(el, "val")
+			case "t":
+				inText = true
+			case "tab":
+				para.WriteString("\t")
+			case "br", "cr":
+				if xmlAttr(el, "type") == "page" {
+					breakPage(false)
+				} else {
+					para.WriteString("\n")
+				}
+			case "lastRenderedPageBreak":
+				breakPage(true)
+			}
+
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if text := strings.TrimSpace(para.String()); text != "" {
+					page.WriteString(docxHeading(style, text))
+					page.WriteString("\n")
+				}
+				para.Reset()
+			}
+
+		case xml.CharData:
+			if inText {
+				para.Write(el)
+				if len(bytes.TrimSpace(el)) > 0 {
+					atBreak = false
+				}
+			}
+		}
+	}
+	if text := strings.TrimSpace(page.String()); text != "" {
+		pages = append(pages, PageData{Page: pageNo, Text: text})
+	}
+
+	return pages, nil
+}
+
+// docxHeading marks Title/Heading 1 paragraphs with double stars and deeper headings with single stars
+func docxHeading(style, text string) string {
+	switch {
+	case style == "Title" || style == "Heading1":
+		return "**" + text + "**"
+	case strings.HasPrefix(style, "Heading"):
+		return "*" + text + "*"
+	}
+	return text
+}
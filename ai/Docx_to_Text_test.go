@@ -0,0 +1,46 @@
+package ai
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDocxDocumentPageNumbers(t *testing.T) {
+	para := func(style, runs string) string {
+		if style != "" {
+			style = `<w:pPr><w:pStyle w:val="` + style + `"/></w:pPr>`
+		}
+		return "<w:p>" + style + runs + "</w:p>"
+	}
+	text := func(s string) string { return "<w:r><w:t>" + s + "</w:t></w:r>" }
+	pageBreak := `<w:r><w:br w:type="page"/></w:r>`
+	rendered := `<w:r><w:lastRenderedPageBreak/></w:r>`
+
+	body := strings.Join([]string{
+		para("Heading1", text("Graphs")),
+		para("", text("A graph has vertices.")+pageBreak),
+		// Word also marks where it rendered the new page; that is the same break
+		para("", rendered+text("Edges join them.")),
+		// A full-page figure: page 3 has no text
+		para("", pageBreak),
+		para("", pageBreak),
+		para("Heading2", text("Breadth first")+rendered+text(" search")),
+		para("", text("It visits neighbours first.")),
+	}, "")
+	document := `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`
+
+	pages, err := parseDocxDocument([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PageData{
+		{Page: 1, Text: "**Graphs**\nA graph has vertices."},
+		{Page: 2, Text: "Edges join them."},
+		{Page: 4, Text: "*Breadth first*"},
+		{Page: 5, Text: "*search*\nIt visits neighbours first."},
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %+v, want %+v", pages, want)
+	}
+}
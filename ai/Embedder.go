@@ -0,0 +1,315 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// Embedder turns text into vectors for semantic retrieval. Documents and queries are
+// embedded separately because some models encode the two sides differently.
+type Embedder interface {
+	EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error)
+	EmbedQuery(ctx context.Context, text string) ([]float32, error)
+	// Name identifies the model, so vectors from different models are never compared
+	Name() string
+}
+
+var (
+	embedderMu sync.Mutex
+	embedder   Embedder
+)
+
+// SetEmbedder overrides the embedder used by the package (e.g. in tests)
+func SetEmbedder(e Embedder) {
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+	embedder = e
+}
+
+// textEmbedder returns the configured embedder, building it from the environment on first use
+func textEmbedder() (Embedder, error) {
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+	if embedder != nil {
+		return embedder, nil
+	}
+
+	e, err := NewEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	embedder = e
+	return embedder, nil
+}
+
+// NewEmbedder builds an embedder from EMBEDDER ("gemini", "openai" or "hash"),
+// defaulting to the backend of LLM_PROVIDER
+func NewEmbedder() (Embedder, error) {
+	name := os.Getenv("EMBEDDER")
+	if name == "" {
+		name = envOr("LLM_PROVIDER", "gemini")
+		if name == "fake" {
+			name = "hash"
+		}
+	}
+
+	dims := envInt("EMBEDDING_DIMENSIONS", 768)
+	switch name {
+	case "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY not set")
+		}
+		return &GeminiEmbedder{
+			APIKey: apiKey,
+			Model:  envOr("GEMINI_EMBEDDING_MODEL", "text-embedding-004"),
+		}, nil
+
+	case "openai":
+		return &OpenAIEmbedder{
+			OpenAIProvider: OpenAIProvider{
+				BaseURL: envOr("OPENAI_BASE_URL", "https://api.openai.com/v1"),
+				APIKey:  os.Getenv("OPENAI_API_KEY"),
+				Model:   envOr("OPENAI_EMBEDDING_MODEL", "text-embedding-3-small"),
+			},
+			Dimensions: dims,
+		}, nil
+
+	case "hash":
+		return &HashEmbedder{Dims: dims}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDER: %s", name)
+	}
+}
+
+// embedChunks computes the embeddings of the chunks with the configured embedder
+func embedChunks(ctx context.Context, chunks []Chunk) error {
+	e, err := textEmbedder()
+	if err != nil {
+		return err
+	}
+
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Text
+	}
+	vectors, err := e.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("error embedding chunks: %v", err)
+	}
+	if len(vectors) != len(chunks) {
+		return fmt.Errorf("embedder returned %d vectors for %d chunks", len(vectors), len(chunks))
+	}
+
+	for i := range chunks {
+		chunks[i].Embedding = vectors[i]
+		chunks[i].EmbeddingModel = e.Name()
+	}
+	return nil
+}
+
+// cosine returns the cosine similarity of two vectors, 0 if their sizes differ
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// =============== Gemini ===============
+
+// GeminiEmbedder uses Gemini's embedding models
+type GeminiEmbedder struct {
+	APIKey string
+	Model  string
+}
+
+// geminiEmbedBatch is the most texts the API accepts in one batch request
+const geminiEmbedBatch = 100
+
+func (g *GeminiEmbedder) Name() string {
+	return "gemini/" + g.Model
+}
+
+func (g *GeminiEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	return g.embed(ctx, genai.TaskTypeRetrievalDocument, texts)
+}
+
+func (g *GeminiEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := g.embed(ctx, genai.TaskTypeRetrievalQuery, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (g *GeminiEmbedder) embed(ctx context.Context, task genai.TaskType, texts []string) ([][]float32, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g.APIKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.EmbeddingModel(g.Model)
+	model.TaskType = task
+
+	var vectors [][]float32
+	for start := 0; start < len(texts); start += geminiEmbedBatch {
+		batch := model.NewBatch()
+		for _, text := range texts[start:min(start+geminiEmbedBatch, len(texts))] {
+			batch.AddContent(genai.Text(text))
+		}
+
+		res, err := model.BatchEmbedContents(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("error embedding content: %v", err)
+		}
+		for _, e := range res.Embeddings {
+			vectors = append(vectors, e.Values)
+		}
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+	return vectors, nil
+}
+
+// =============== OpenAI-compatible ===============
+
+// OpenAIEmbedder talks to any server implementing the OpenAI embeddings API
+type OpenAIEmbedder struct {
+	OpenAIProvider
+	Dimensions int // requested vector size, 0 for the model's default
+}
+
+type openAIEmbeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (o *OpenAIEmbedder) Name() string {
+	return "openai/" + o.Model
+}
+
+func (o *OpenAIEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(openAIEmbeddingRequest{Model: o.Model, Input: texts, Dimensions: o.Dimensions})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp openAIEmbeddingResponse
+	if err := o.post(ctx, "/embeddings", body, &resp); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+func (o *OpenAIEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := o.EmbedDocuments(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// =============== Hash ===============
+
+// HashEmbedder is a deterministic offline embedder for tests and local development.
+// It hashes each word (and each pair of adjacent words) into one of Dims buckets, so
+// texts sharing vocabulary get similar vectors without any model.
+type HashEmbedder struct {
+	Dims int
+}
+
+func (h *HashEmbedder) Name() string {
+	return fmt.Sprintf("hash/%d", h.Dims)
+}
+
+func (h *HashEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = h.embed(text)
+	}
+	return vectors, nil
+}
+
+func (h *HashEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return h.embed(text), nil
+}
+
+func (h *HashEmbedder) embed(text string) []float32 {
+	v := make([]float32, max(h.Dims, 1))
+	add := func(feature string) {
+		f := fnv.New64a()
+		f.Write([]byte(feature))
+		sum := f.Sum64()
+		sign := float32(1)
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		v[sum%uint64(len(v))] += sign
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, w := range words {
+		add(w)
+		if i > 0 {
+			add(words[i-1] + " " + w)
+		}
+	}
+
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range v {
+			v[i] *= scale
+		}
+	}
+	return v
+}
@@ -0,0 +1,361 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Question types and difficulties of generated exam questions
+const (
+	QuestionMultipleChoice = "multiple_choice"
+	QuestionTrueFalse      = "true_false"
+	QuestionShortAnswer    = "short_answer"
+
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// ExamRequest describes the exam to generate. The binding tags are checked by Gin
+// when the request is bound.
+type ExamRequest struct {
+	Topics        []string      `json:"topics" binding:"max=20,dive,min=1,max=200"`
+	DatasetIDs    []int         `json:"dataset_ids" binding:"max=50,dive,min=1"` // empty for all of the user's datasets
+	Count         int           `json:"count" binding:"required,min=1,max=50"`
+	DifficultyMix DifficultyMix `json:"difficulty_mix"`
+	QuestionTypes []string      `json:"question_types" binding:"dive,oneof=multiple_choice true_false short_answer"` // empty for any type
+	BloomLevel    string        `json:"bloom_level" binding:"omitempty,oneof=remember understand apply analyze evaluate create"`
+	Language      string        `json:"language" binding:"omitempty,min=2,max=40"` // default English
+}
+
+// DifficultyMix weighs the difficulties of the questions, e.g. 20/50/30. All zero means all medium.
+type DifficultyMix struct {
+	Easy   int `json:"easy" binding:"min=0,max=100"`
+	Medium int `json:"medium" binding:"min=0,max=100"`
+	Hard   int `json:"hard" binding:"min=0,max=100"`
+}
+
+// bloomLevels explains each level of Bloom's taxonomy to the generator
+var bloomLevels = map[string]string{
+	"remember":   "recall facts and basic concepts",
+	"understand": "explain ideas or concepts",
+	"apply":      "use the information in new situations",
+	"analyze":    "draw connections among ideas",
+	"evaluate":   "justify a stand or decision",
+	"create":     "produce new or original work",
+}
+
+// Counts splits count questions between the difficulties in proportion to the weights,
+// giving leftover questions to the largest remainders (easy first on ties)
+func (m DifficultyMix) Counts(count int) (easy, medium, hard int) {
+	weights := []int{m.Easy, m.Medium, m.Hard}
+	total := m.Easy + m.Medium + m.Hard
+	if total == 0 {
+		return 0, count, 0
+	}
+
+	counts := make([]int, 3)
+	remainders := make([]int, 3)
+	assigned := 0
+	for i, w := range weights {
+		counts[i] = count * w / total
+		remainders[i] = count * w % total
+		assigned += counts[i]
+	}
+	for ; assigned < count; assigned++ {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		counts[best]++
+		remainders[best] = -1
+	}
+	return counts[0], counts[1], counts[2]
+}
+
+// query is the text the exam's context is retrieved with
+func (r *ExamRequest) query() string {
+	return strings.Join(r.Topics, ", ")
+}
+
+// instructions renders the request as prompt lines, always in the same order and wording
+func (r *ExamRequest) instructions() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Generate exactly %d questions.", r.Count))
+
+	if len(r.Topics) > 0 {
+		lines = append(lines, "Topics: "+strings.Join(r.Topics, "; ")+".")
+	} else {
+		lines = append(lines, "Topics: any topic covered by the context.")
+	}
+
+	easy, medium, hard := r.DifficultyMix.Counts(r.Count)
+	lines = append(lines, fmt.Sprintf("Difficulty: %d easy, %d medium, %d hard.", easy, medium, hard))
+
+	types := r.QuestionTypes
+	if len(types) == 0 {
+		types = []string{QuestionMultipleChoice, QuestionTrueFalse, QuestionShortAnswer}
+	}
+	lines = append(lines, "Question types: only "+strings.Join(types, ", ")+".")
+
+	if r.BloomLevel != "" {
+		lines = append(lines, fmt.Sprintf("Bloom's taxonomy level: %s (questions should ask the student to %s).", r.BloomLevel, bloomLevels[r.BloomLevel]))
+	}
+
+	language := r.Language
+	if language == "" {
+		language = "English"
+	}
+	lines = append(lines, "Write the questions, options, answers and explanations in "+language+".")
+	return strings.Join(lines, "\n")
+}
+
+// allowsType reports whether the request accepts questions of type t
+func (r *ExamRequest) allowsType(t string) bool {
+	if len(r.QuestionTypes) == 0 {
+		return true
+	}
+	for _, allowed := range r.QuestionTypes {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ExamQuestion is one generated exam question
+type ExamQuestion struct {
+	Question      string   `json:"question"`
+	Type          string   `json:"type"`
+	Options       []string `json:"options,omitempty"` // multiple choice and true/false only
+	CorrectAnswer string   `json:"correct_answer"`
+	Explanation   string   `json:"explanation"`
+	Difficulty    string   `json:"difficulty"`
+	SourceChunk   string   `json:"source_chunk,omitempty"` // ID of the chunk the question is based on
+}
+
+// examSchema constrains the exam generator's reply
+var examSchema = &JSONSchema{
+	Type: "object",
+	Properties: map[string]*JSONSchema{
+		"questions": {
+			Type: "array",
+			Items: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"question":       {Type: "string"},
+					"type":           {Type: "string", Enum: []string{QuestionMultipleChoice, QuestionTrueFalse, QuestionShortAnswer}},
+					"options":        {Type: "array", Items: &JSONSchema{Type: "string"}, Description: "Answer choices, empty for short answer questions"},
+					"correct_answer": {Type: "string", Description: "For multiple choice, the text of the correct option"},
+					"explanation":    {Type: "string"},
+					"difficulty":     {Type: "string", Enum: []string{DifficultyEasy, DifficultyMedium, DifficultyHard}},
+					"source_chunk":   {Type: "string", Description: "ID of the context part the question is based on, e.g. 12-3"},
+				},
+				Required: []string{"question", "type", "options", "correct_answer", "explanation", "difficulty", "source_chunk"},
+			},
+		},
+	},
+	Required: []string{"questions"},
+}
+
+// generateExam asks the LLM for exam questions as JSON, validating and repairing its
+// reply and asking again (up to EXAM_JSON_RETRIES times) when nothing usable comes back
+func generateExam(ctx context.Context, llm LLMProvider, prompt string, req *ExamRequest, chunks []ScoredChunk) ([]ExamQuestion, error) {
+	ids := map[string]bool{}
+	for _, c := range chunks {
+		ids[c.ChunkID] = true
+	}
+
+	retries := max(envInt("EXAM_JSON_RETRIES", 2), 0)
+	attempt := prompt
+	var lastErr error
+	for i := 0; i <= retries; i++ {
+		reply, err := generateJSON(ctx, llm, attempt, examSchema)
+		if err != nil {
+			return nil, err
+		}
+
+		questions, err := parseExam(reply, ids)
+		if err == nil {
+			questions, err = fitExam(questions, req)
+		}
+		if err == nil {
+			return questions, nil
+		}
+		lastErr = err
+		fmt.Printf("Exam reply rejected (attempt %d): %v\n", i+1, err)
+		attempt = prompt + "\n\nYour previous reply could not be used: " + err.Error() +
+			". Reply again with valid JSON matching the schema."
+	}
+	return nil, fmt.Errorf("exam generation failed: %v", lastErr)
+}
+
+// fitExam drops questions of types the request doesn't accept and extra questions
+func fitExam(questions []ExamQuestion, req *ExamRequest) ([]ExamQuestion, error) {
+	var fitted []ExamQuestion
+	for _, q := range questions {
+		if req.allowsType(q.Type) {
+			fitted = append(fitted, q)
+		}
+	}
+	if len(fitted) == 0 {
+		return nil, fmt.Errorf("no questions of type %s", strings.Join(req.QuestionTypes, " or "))
+	}
+	if len(fitted) > req.Count {
+		fitted = fitted[:req.Count]
+	}
+	return fitted, nil
+}
+
+// parseExam decodes the exam generator's reply, repairing what it can and dropping
+// questions that are still invalid. It fails if no valid question is left.
+func parseExam(reply string, chunkIDs map[string]bool) ([]ExamQuestion, error) {
+	raw := extractJSON(reply)
+
+	var wrapped struct {
+		Questions []ExamQuestion `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(raw), &wrapped); err != nil {
+		// Some models reply with the bare array
+		if err := json.Unmarshal([]byte(raw), &wrapped.Questions); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+	}
+
+	var questions []ExamQuestion
+	var problems []string
+	for i, q := range wrapped.Questions {
+		if err := repairQuestion(&q, chunkIDs); err != nil {
+			problems = append(problems, fmt.Sprintf("question %d: %v", i+1, err))
+			continue
+		}
+		questions = append(questions, q)
+	}
+	if len(problems) > 0 {
+		fmt.Println("Dropped invalid exam questions:", strings.Join(problems, "; "))
+	}
+	if len(questions) == 0 {
+		if len(problems) > 0 {
+			return nil, errors.New(problems[0])
+		}
+		return nil, errors.New("no questions")
+	}
+	return questions, nil
+}
+
+// repairQuestion normalizes a question's fields and checks it is usable
+func repairQuestion(q *ExamQuestion, chunkIDs map[string]bool) error {
+	q.Question = strings.TrimSpace(q.Question)
+	q.CorrectAnswer = strings.TrimSpace(q.CorrectAnswer)
+	q.Explanation = strings.TrimSpace(q.Explanation)
+	if q.Question == "" {
+		return errors.New("empty question")
+	}
+	if q.CorrectAnswer == "" {
+		return errors.New("no correct answer")
+	}
+
+	var options []string
+	for _, o := range q.Options {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	q.Options = options
+
+	q.Type = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(q.Type)), " ", "_")
+	switch q.Type {
+	case "mcq", "multiple-choice", "multiplechoice":
+		q.Type = QuestionMultipleChoice
+	case "true/false", "true-false", "truefalse", "boolean":
+		q.Type = QuestionTrueFalse
+	case "short", "open", "open_ended", "short-answer":
+		q.Type = QuestionShortAnswer
+	case QuestionMultipleChoice, QuestionTrueFalse, QuestionShortAnswer:
+	default:
+		// Infer the type from the shape of the question
+		switch {
+		case len(options) > 2:
+			q.Type = QuestionMultipleChoice
+		case isTrueFalse(q.CorrectAnswer):
+			q.Type = QuestionTrueFalse
+		default:
+			q.Type = QuestionShortAnswer
+		}
+	}
+
+	switch q.Type {
+	case QuestionMultipleChoice:
+		if len(options) < 2 {
+			return errors.New("multiple choice question with fewer than two options")
+		}
+		answer, ok := matchOption(q.CorrectAnswer, options)
+		if !ok {
+			return fmt.Errorf("correct answer %q is not one of the options", q.CorrectAnswer)
+		}
+		q.CorrectAnswer = answer
+
+	case QuestionTrueFalse:
+		if !isTrueFalse(q.CorrectAnswer) {
+			return fmt.Errorf("true/false question answered %q", q.CorrectAnswer)
+		}
+		q.Options = []string{"True", "False"}
+		if strings.EqualFold(q.CorrectAnswer, "true") {
+			q.CorrectAnswer = "True"
+		} else {
+			q.CorrectAnswer = "False"
+		}
+
+	case QuestionShortAnswer:
+		q.Options = nil
+	}
+
+	q.Difficulty = strings.ToLower(strings.TrimSpace(q.Difficulty))
+	if q.Difficulty != DifficultyEasy && q.Difficulty != DifficultyHard {
+		q.Difficulty = DifficultyMedium
+	}
+
+	// A source the model made up is worse than none
+	q.SourceChunk = strings.TrimPrefix(strings.TrimSpace(q.SourceChunk), "chunk:")
+	if !chunkIDs[q.SourceChunk] {
+		q.SourceChunk = ""
+	}
+	return nil
+}
+
+// optionLabel matches a letter label in front of an option, e.g. "B) " or "(c). "
+var optionLabel = regexp.MustCompile(`^\(?[A-Za-z][).:]\s+`)
+
+// matchOption finds the option a multiple choice answer refers to, by its text or by
+// its letter ("B", "b)", "(B)")
+func matchOption(answer string, options []string) (string, bool) {
+	for _, o := range options {
+		if strings.EqualFold(o, answer) {
+			return o, true
+		}
+	}
+
+	letter := strings.ToUpper(strings.Trim(answer, " ().:"))
+	if len(letter) == 1 && letter[0] >= 'A' && int(letter[0]-'A') < len(options) {
+		return options[letter[0]-'A'], true
+	}
+
+	// Options written as "B) text" by the model
+	for _, o := range options {
+		if strings.EqualFold(optionLabel.ReplaceAllString(o, ""), answer) {
+			return o, true
+		}
+	}
+	return "", false
+}
+
+// isTrueFalse reports whether an answer is "true" or "false"
+func isTrueFalse(answer string) bool {
+	return strings.EqualFold(answer, "true") || strings.EqualFold(answer, "false")
+}
@@ -0,0 +1,247 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRepairQuestion(t *testing.T) {
+	ids := map[string]bool{"4-2": true}
+	tests := []struct {
+		name    string
+		in      ExamQuestion
+		want    ExamQuestion
+		wantErr bool
+	}{
+		{
+			name: "multiple choice answered by letter",
+			in:   ExamQuestion{Question: " Largest planet? ", Type: "MCQ", Options: []string{"Mars", " Jupiter ", ""}, CorrectAnswer: "b)", Difficulty: "Easy", SourceChunk: "chunk:4-2"},
+			want: ExamQuestion{Question: "Largest planet?", Type: QuestionMultipleChoice, Options: []string{"Mars", "Jupiter"}, CorrectAnswer: "Jupiter", Difficulty: DifficultyEasy, SourceChunk: "4-2"},
+		},
+		{
+			name: "multiple choice answered by a labelled option's text",
+			in:   ExamQuestion{Question: "Q", Type: "multiple choice", Options: []string{"A) Mars", "B) Venus"}, CorrectAnswer: "venus"},
+			want: ExamQuestion{Question: "Q", Type: QuestionMultipleChoice, Options: []string{"A) Mars", "B) Venus"}, CorrectAnswer: "B) Venus", Difficulty: DifficultyMedium},
+		},
+		{
+			name: "true/false normalized",
+			in:   ExamQuestion{Question: "Q", Type: "True/False", CorrectAnswer: "FALSE", Difficulty: "HARD"},
+			want: ExamQuestion{Question: "Q", Type: QuestionTrueFalse, Options: []string{"True", "False"}, CorrectAnswer: "False", Difficulty: DifficultyHard},
+		},
+		{
+			name: "type inferred from options",
+			in:   ExamQuestion{Question: "Q", Type: "quiz", Options: []string{"1", "2", "3"}, CorrectAnswer: "3"},
+			want: ExamQuestion{Question: "Q", Type: QuestionMultipleChoice, Options: []string{"1", "2", "3"}, CorrectAnswer: "3", Difficulty: DifficultyMedium},
+		},
+		{
+			name: "type inferred from the answer",
+			in:   ExamQuestion{Question: "Q", CorrectAnswer: "true"},
+			want: ExamQuestion{Question: "Q", Type: QuestionTrueFalse, Options: []string{"True", "False"}, CorrectAnswer: "True", Difficulty: DifficultyMedium},
+		},
+		{
+			name: "short answer drops options and made up sources",
+			in:   ExamQuestion{Question: "Q", Type: "open", Options: []string{"x"}, CorrectAnswer: "42", Difficulty: "brutal", SourceChunk: "9-9"},
+			want: ExamQuestion{Question: "Q", Type: QuestionShortAnswer, CorrectAnswer: "42", Difficulty: DifficultyMedium},
+		},
+		{name: "empty question", in: ExamQuestion{Question: " ", CorrectAnswer: "a"}, wantErr: true},
+		{name: "no answer", in: ExamQuestion{Question: "Q", Type: QuestionShortAnswer}, wantErr: true},
+		{name: "answer not an option", in: ExamQuestion{Question: "Q", Type: QuestionMultipleChoice, Options: []string{"a", "b"}, CorrectAnswer: "c"}, wantErr: true},
+		{name: "single option", in: ExamQuestion{Question: "Q", Type: QuestionMultipleChoice, Options: []string{"a"}, CorrectAnswer: "a"}, wantErr: true},
+		{name: "true/false answered otherwise", in: ExamQuestion{Question: "Q", Type: QuestionTrueFalse, CorrectAnswer: "maybe"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		q := tt.in
+		err := repairQuestion(&q, ids)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: repaired into %+v, want an error", tt.name, q)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(q, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, q, tt.want)
+		}
+	}
+}
+
+func TestParseExam(t *testing.T) {
+	ids := map[string]bool{"1-1": true}
+	tests := []struct {
+		name    string
+		reply   string
+		want    []string // questions kept
+		wantErr string
+	}{
+		{
+			name:  "object",
+			reply: `{"questions": [{"question": "A?", "type": "short_answer", "correct_answer": "a"}, {"question": "", "correct_answer": "b"}]}`,
+			want:  []string{"A?"},
+		},
+		{
+			name:  "bare array in a code fence",
+			reply: "Here you go:\n```json\n[{\"question\": \"A?\", \"correct_answer\": \"true\"}, {\"question\": \"B?\", \"correct_answer\": \"b\"}]\n```",
+			want:  []string{"A?", "B?"},
+		},
+		{name: "not JSON", reply: "Sorry, I can't.", wantErr: "invalid JSON"},
+		{name: "nothing usable", reply: `{"questions": [{"question": "A?"}]}`, wantErr: "question 1: no correct answer"},
+		{name: "no questions", reply: `{"questions": []}`, wantErr: "no questions"},
+	}
+
+	for _, tt := range tests {
+		questions, err := parseExam(tt.reply, ids)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, q := range questions {
+			got = append(got, q.Question)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: questions = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// useFakeBackends builds the package's LLM, embedder, store and retriever from the
+// environment the way the server does, with the offline fake provider and a file store
+func useFakeBackends(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", "fake")
+	t.Setenv("EMBEDDER", "")
+	t.Setenv("DATASET_STORE", "file")
+	t.Setenv("DATASET_DIR", t.TempDir())
+	t.Setenv("RETRIEVER", "hybrid")
+	t.Setenv("VECTOR_STORE", "memory")
+
+	reset := func() {
+		SetLLMProvider(nil)
+		SetEmbedder(nil)
+		SetDatasetStore(nil)
+		SetRetriever(nil)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestFakeProviderEndToEnd(t *testing.T) {
+	useFakeBackends(t)
+	ctx := context.Background()
+
+	pages := []PageData{
+		{Page: 1, Text: "**Planets**\nJupiter is the largest planet of the solar system. Saturn has the most visible rings."},
+		{Page: 2, Text: "**Stars**\nThe Sun is a yellow dwarf star. Stars fuse hydrogen into helium."},
+	}
+	if err := Format(ctx, Source{UserID: 3, DatasetID: 12, Filename: "astronomy.pdf"}, pages, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := AI(ctx, "qa", "Which planet is the largest?", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Citations) == 0 {
+		t.Fatalf("answer %q cites no chunk", answer.Text)
+	}
+	for _, c := range answer.Citations {
+		if c.DatasetID != 12 || c.Filename != "astronomy.pdf" || c.Page == 0 {
+			t.Errorf("citation %+v does not point into the dataset", c)
+		}
+	}
+
+	SetLLMProvider(&FakeProvider{JSONReply: examReply(t, 2)})
+	req := &ExamRequest{Topics: []string{"planets"}, Count: 2, QuestionTypes: []string{QuestionMultipleChoice}}
+	questions, err := GenerateExam(ctx, 3, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) != 2 {
+		t.Fatalf("got %d questions, want 2", len(questions))
+	}
+	for _, q := range questions {
+		if q.Type != QuestionMultipleChoice || !strings.HasPrefix(q.SourceChunk, "12-") {
+			t.Errorf("question %+v is not a multiple choice question sourced from the dataset", q)
+		}
+		if _, ok := matchOption(q.CorrectAnswer, q.Options); !ok {
+			t.Errorf("question %+v is answered by none of its options", q)
+		}
+	}
+
+	if _, err := AI(ctx, "qa", "Anything?", 4); err != ErrDatasetNotFound {
+		t.Errorf("question of a user without datasets: err = %v, want ErrDatasetNotFound", err)
+	}
+}
+
+// promptChunkHeader matches the header of a context part of a prompt, with its chunk ID
+var promptChunkHeader = regexp.MustCompile(`(?m)^\[chunk:([\w-]+) \|[^\n]*\]$`)
+
+// examReply answers exam prompts with count multiple choice questions, each about a
+// context part of the prompt in turn
+func examReply(t *testing.T, count int) func(prompt string, schema *JSONSchema) string {
+	return func(prompt string, schema *JSONSchema) string {
+		if schema != examSchema {
+			t.Fatalf("GenerateJSON called with a schema other than the exam's")
+		}
+		var ids []string
+		for _, m := range promptChunkHeader.FindAllStringSubmatch(prompt, -1) {
+			ids = append(ids, m[1])
+		}
+		if len(ids) == 0 {
+			t.Fatalf("exam prompt has no context:\n%s", prompt)
+		}
+
+		questions := make([]ExamQuestion, count)
+		for i := range questions {
+			id := ids[i%len(ids)]
+			statement := "The course material states it in part " + id + "."
+			questions[i] = ExamQuestion{
+				Type:          QuestionMultipleChoice,
+				Question:      "Which statement is made in the course material?",
+				Options:       []string{statement, "None of the above"},
+				CorrectAnswer: statement,
+				Explanation:   statement,
+				Difficulty:    DifficultyMedium,
+				SourceChunk:   id,
+			}
+		}
+		data, err := json.Marshal(map[string]interface{}{"questions": questions})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+}
+
+func TestDifficultyMixCounts(t *testing.T) {
+	tests := []struct {
+		mix                DifficultyMix
+		count              int
+		easy, medium, hard int
+	}{
+		{DifficultyMix{}, 10, 0, 10, 0},
+		{DifficultyMix{Easy: 20, Medium: 50, Hard: 30}, 10, 2, 5, 3},
+		{DifficultyMix{Easy: 1, Medium: 1, Hard: 1}, 10, 4, 3, 3},
+		{DifficultyMix{Hard: 5}, 7, 0, 0, 7},
+		{DifficultyMix{Easy: 33, Medium: 33, Hard: 34}, 5, 2, 1, 2},
+		{DifficultyMix{Easy: 1, Medium: 2}, 1, 0, 1, 0},
+		{DifficultyMix{Easy: 100, Medium: 100, Hard: 100}, 50, 17, 17, 16},
+	}
+	for _, tt := range tests {
+		easy, medium, hard := tt.mix.Counts(tt.count)
+		if easy != tt.easy || medium != tt.medium || hard != tt.hard {
+			t.Errorf("%+v.Counts(%d) = %d/%d/%d, want %d/%d/%d", tt.mix, tt.count, easy, medium, hard, tt.easy, tt.medium, tt.hard)
+		}
+	}
+}
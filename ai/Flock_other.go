@@ -0,0 +1,15 @@
+//go:build !unix
+
+package ai
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable; writers in one process are
+// still serialized by the FileStore mutex
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package ai
+
+import (
+	"context"
+	"strings"
+
+	"github.com/edubank/db"
+)
+
+// FulltextRetriever ranks chunks with Postgres full-text search over the GIN index on
+// dataset_chunks.text_search, so, like PgvectorRetriever, only the top k chunks leave
+// the database. It needs DATASET_STORE=postgres (migrations/009_chunk_fulltext.sql).
+type FulltextRetriever struct{}
+
+func (r *FulltextRetriever) Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error) {
+	query := fulltextQuery(question)
+	if query == "" {
+		return nil, nil
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+chunkColumns+", ts_rank_cd(c.text_search, q, 1) AS score "+
+			"FROM dataset_chunks c JOIN datasets d ON d.id = c.dataset_id, to_tsquery('simple', $2) q "+
+			"WHERE d.user_id=$1 AND c.text_search @@ q "+
+			"AND (cardinality($4::int[]) = 0 OR c.dataset_id = ANY($4::int[])) "+
+			"ORDER BY score DESC, c.dataset_id, c.seq LIMIT $3",
+		user.UserID, query, k, append([]int{}, user.DatasetIDs...))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ScoredChunk
+	for rows.Next() {
+		var sc ScoredChunk
+		if err := rows.Scan(append(chunkFields(&sc.Chunk), &sc.Score)...); err != nil {
+			return nil, err
+		}
+		results = append(results, sc)
+	}
+	return results, rows.Err()
+}
+
+// fulltextQuery turns a question into a tsquery matching any of its terms, like BM25.
+// The terms only hold letters and digits, so they need no quoting.
+func fulltextQuery(question string) string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, t := range tokenize(question) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return strings.Join(terms, " | ")
+}
@@ -1,200 +1,113 @@
 package ai
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"math"
+	"regexp"
 	"strings"
 )
 
-// Struct for storing extracted text from PDF pages
+// Struct for storing extracted text from PDF pages or timed video sections
 type PageData struct {
-	Page int    `json:"page"`
-	Text string `json:"content"`
+	Page   int         `json:"page"`
+	Text   string      `json:"content"`
+	Start  float64     `json:"start,omitempty"` // seconds into the recording, videos only
+	End    float64     `json:"end,omitempty"`
+	Slides []SlideText `json:"slides,omitempty"` // slides shown during the section, videos only
 }
 
-// Struct for PDF JSON format
-type PDFExtracted struct {
-	Pages []PageData `json:"pages"`
-}
-
-type Data struct {
-	Topic   string      `json:"topic"`
-	Content interface{} `json:"content"`
-}
-
-// Function to extract the filename from the given text
-func extractFilename(text string) string {
-	lines := strings.Split(text, "\n")
-	if len(lines) > 0 {
-		// Take the first line and remove asterisks and extra spaces
-		title := strings.TrimSpace(strings.TrimPrefix(lines[0], "**"))
-		title = strings.TrimSpace(strings.TrimSuffix(title, "**"))
-		title = strings.ReplaceAll(title, ":", "")  // Remove colons for filename safety
-		title = strings.ReplaceAll(title, " ", "_") // Replace spaces with underscores
-		return title
+// inlineMarker matches a [m:ss] or [h:mm:ss] marker anywhere in a cleaned transcript
+var inlineMarker = regexp.MustCompile(`\[(?:(\d+):)?(\d+):(\d{2})\]`)
+
+// pageText is the text stored for a page. The slides shown during a video section are
+// merged into the transcript by time, each on its own line with a [m:ss] marker, so the
+// text reads in the order things were said and shown.
+func pageText(p PageData) string {
+	text := strings.TrimSpace(p.Text)
+	if len(p.Slides) == 0 {
+		return text
+	}
+
+	slides := p.Slides
+	var lines []string
+	addSlidesBefore := func(t float64) {
+		for len(slides) > 0 && slides[0].Time < t {
+			lines = append(lines, fmt.Sprintf("[%s] Slide: %s",
+				formatTimestamp(slides[0].Time), strings.Join(strings.Fields(slides[0].Text), " ")))
+			slides = slides[1:]
+		}
 	}
-	return "formatted_output" // Fallback filename
-}
 
-// Clean and normalize text for AI processing
-func cleanText(text string) string {
-	text = strings.ReplaceAll(text, "\n", " ")     // Remove line breaks
-	text = strings.ReplaceAll(text, "\t", " ")     // Remove tabs
-	text = strings.Join(strings.Fields(text), " ") // Remove extra spaces
-	return text
-}
-
-// Save extracted PDF text in a structured JSON
-func savePDFAsJSON(pages []PageData, filename string, jsonFileName string) error {
-	var data []Data
-
-	_, err := os.Stat(jsonFileName)
-	fmt.Println("JSON File Name: ", jsonFileName)
-	if os.IsNotExist(err) {
-		createdFile, err := os.Create(jsonFileName)
-		if err != nil {
-			fmt.Println("Error making file: ", err)
-			return err
+	for _, line := range strings.Split(text, "\n") {
+		// Every marker starts a line, so a slide can go between any two timed sentences
+		markers := inlineMarker.FindAllStringIndex(line, -1)
+		if len(markers) == 0 {
+			lines = append(lines, line)
+			continue
 		}
-		defer createdFile.Close()
-
-		_, err = createdFile.WriteString("[]")
-		if err != nil {
-			fmt.Println("Error writing file: ", err)
-			return err
+		if head := strings.TrimSpace(line[:markers[0][0]]); head != "" {
+			lines = append(lines, head)
 		}
-	}
-
-	file, err := ioutil.ReadFile(jsonFileName)
-	if err != nil {
-		fmt.Println("Error reading file: ", err)
-		return err
-	}
-
-	err = json.Unmarshal(file, &data)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-		return err
-	}
-
-	newTopic := Data{
-		Topic:   filename,
-		Content: pages,
-	}
-
-	// Check if topic already exists
-	found := false
-	for i, d := range data {
-		if d.Topic == filename {
-			data[i] = newTopic // replace existing
-			found = true
-			break
+		for i, m := range markers {
+			end := len(line)
+			if i+1 < len(markers) {
+				end = markers[i+1][0]
+			}
+			t := inlineMarker.FindStringSubmatch(line[m[0]:m[1]])
+			addSlidesBefore(clockSeconds(t[1], t[2], t[3]))
+			lines = append(lines, strings.TrimSpace(line[m[0]:end]))
 		}
 	}
-	if !found {
-		data = append(data, newTopic)
-	}
-
+	addSlidesBefore(math.Inf(1))
+	return strings.Join(lines, "\n")
+}
 
-	updatedJSON, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-		return err
+// buildChunks splits the extracted pages (or image text) of a dataset into chunks
+// along their headings
+func buildChunks(src Source, pages []PageData, imageText string) []Chunk {
+	if len(pages) == 0 && strings.TrimSpace(imageText) != "" {
+		pages = []PageData{{Page: 1, Text: imageText}}
 	}
 
-	err = ioutil.WriteFile(jsonFileName, updatedJSON, 0644)
-	if err != nil {
-		fmt.Println("Error writing file:", err)
-		return err
+	chunks := newChunker().Split(pages)
+	for i := range chunks {
+		chunks[i].Version = ChunkSchemaVersion
+		chunks[i].DatasetID = src.DatasetID
+		chunks[i].ChunkID = fmt.Sprintf("%d-%d", src.DatasetID, i+1)
+		chunks[i].Source = src.Filename
+		chunks[i].Hash = hashText(chunks[i].Text)
 	}
-
-	return nil
+	return chunks
 }
 
-// Save extracted image text as JSONL (AI-friendly)
-func saveImageAsJSONL(text string, filename string, jsonFileName string) error {
-	var data []Data
-
-	_, err := os.Stat(jsonFileName)
-	fmt.Println("JSON File Name: ", jsonFileName)
-	if os.IsNotExist(err) {
-		createdFile, err := os.Create(jsonFileName)
-		if err != nil {
-			fmt.Println("Error making file: ", err)
-			return err
-		}
-		defer createdFile.Close()
-
-		_, err = createdFile.WriteString("[]")
-		if err != nil {
-			fmt.Println("Error writing file: ", err)
-			return err
-		}
+// Format converts the extracted pages of a PDF, document or recording, or the text of
+// an image, into chunks and stores them in the dataset store, replacing any chunks
+// previously extracted from the same dataset
+func Format(ctx context.Context, src Source, pages []PageData, imageText string) error {
+	chunks := buildChunks(src, pages, imageText)
+	if len(chunks) == 0 {
+		return errors.New("no valid input provided")
 	}
 
-	file, err := ioutil.ReadFile(jsonFileName)
-	if err != nil {
-		fmt.Println("Error reading file: ", err)
+	Emit(ctx, Event{Stage: StageEmbedding})
+	if err := embedChunks(ctx, chunks); err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(file, &data)
+	s, err := datasetStore()
 	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
 		return err
 	}
-
-	newTopic := Data{
-		Topic:   filename,
-		Content: text,
-	}
-
-	data = append(data, newTopic)
-	updatedJSON, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
+	if err := s.ReplaceDataset(ctx, src.UserID, src.DatasetID, chunks); err != nil {
+		fmt.Println("Error saving chunks:", err)
 		return err
 	}
-
-	err = ioutil.WriteFile(jsonFileName, updatedJSON, 0644)
-	if err != nil {
-		fmt.Println("Error writing file:", err)
-		return err
+	if err := updateBM25Index(ctx, s, src.UserID); err != nil {
+		return fmt.Errorf("error indexing dataset: %v", err)
 	}
 
+	fmt.Printf("%d chunks of %s added to dataset %d\n", len(chunks), src.Filename, src.DatasetID)
 	return nil
 }
-
-func Format(pages []PageData, imageText string, jsonFilename string) error {
-	// If pages are provided, process PDF
-	if len(pages) > 0 {
-		filename := extractFilename(pages[0].Text)
-		err := savePDFAsJSON(pages, filename, jsonFilename)
-
-		if err != nil {
-			fmt.Println("Error saving file:", err)
-			return err
-		}
-
-		fmt.Println("Data of ", filename+" added to ai/dataset.jsonl")
-		return nil
-	} else if imageText != "" {
-		// If image text is provided, process image
-		filename := extractFilename(imageText)
-		cleanedText := cleanText(imageText)
-		err := saveImageAsJSONL(cleanedText, filename, jsonFilename)
-
-		if err != nil {
-			fmt.Println("Error saving file:", err)
-			return err
-		}
-		
-		fmt.Println("Data of ", filename+" added to ai/dataset.jsonl")
-		return nil
-	}
-
-	return errors.New("no valid input provided")
-}
@@ -0,0 +1,100 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageTextMergesSlidesByTime(t *testing.T) {
+	p := PageData{
+		Page:  1,
+		Text:  "**Sorting**\n[0:05] Today we sort. [0:40] First, bubble sort.\n[1:30] Then merge sort.",
+		Start: 0,
+		End:   120,
+		Slides: []SlideText{
+			{Time: 0, Text: "Sorting\nalgorithms"},
+			{Time: 50, Text: "Bubble sort"},
+			{Time: 110, Text: "Questions?"},
+		},
+	}
+
+	want := strings.Join([]string{
+		"**Sorting**",
+		"[0:00] Slide: Sorting algorithms",
+		"[0:05] Today we sort.",
+		"[0:40] First, bubble sort.",
+		"[0:50] Slide: Bubble sort",
+		"[1:30] Then merge sort.",
+		"[1:50] Slide: Questions?",
+	}, "\n")
+	if got := pageText(p); got != want {
+		t.Errorf("pageText:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPageTextWithoutSlides(t *testing.T) {
+	p := PageData{Page: 3, Text: "  Plain text. [0:10] kept as is.  "}
+	if got, want := pageText(p), "Plain text. [0:10] kept as is."; got != want {
+		t.Errorf("pageText = %q, want %q", got, want)
+	}
+}
+
+func TestBuildChunksTimesNeverDecrease(t *testing.T) {
+	t.Setenv("CHUNK_MAX_TOKENS", "12")
+	t.Setenv("CHUNK_OVERLAP_TOKENS", "4")
+
+	var sb strings.Builder
+	sb.WriteString("**Graphs**\n")
+	for sec := 0; sec < 300; sec += 30 {
+		sb.WriteString("[" + formatTimestamp(float64(sec)) + "] A graph has vertices and edges between them. ")
+		if sec == 150 {
+			sb.WriteString("\n*Traversal*\n")
+		}
+	}
+	sections := []PageData{
+		{Page: 1, Text: sb.String(), Start: 0, End: 300},
+		{Page: 2, Text: "[5:00] Breadth first search visits neighbours first.", Start: 300, End: 330},
+	}
+	slides := []SlideText{{Time: 15, Text: "Graphs"}, {Time: 170, Text: "BFS and DFS"}, {Time: 270, Text: "Summary"}}
+	pages := attachSlides(sections, slides)
+
+	chunks := buildChunks(Source{DatasetID: 7, Filename: "lecture.mp4"}, pages, "")
+	if len(chunks) < 5 {
+		t.Fatalf("got %d chunks, want the sections split by the token budget", len(chunks))
+	}
+
+	var slideChunks int
+	for i, c := range chunks {
+		if c.TimeEnd < c.TimeStart {
+			t.Errorf("chunk %d ends at %v before it starts at %v", i, c.TimeEnd, c.TimeStart)
+		}
+		if i > 0 {
+			prev := chunks[i-1]
+			if c.TimeStart < prev.TimeStart || c.TimeEnd < prev.TimeEnd {
+				t.Errorf("chunk %d (%s) goes back in time after chunk %d (%s)", i, c.Location(), i-1, prev.Location())
+			}
+		}
+		if strings.Contains(c.Text, "Slide:") {
+			slideChunks++
+		}
+	}
+	if slideChunks == 0 {
+		t.Error("no chunk contains the slides")
+	}
+}
+
+func TestBuildChunksSlidesOnly(t *testing.T) {
+	pages := attachSlides(nil, []SlideText{{Time: 12, Text: "Title"}, {Time: 95, Text: "Agenda"}})
+
+	chunks := buildChunks(Source{DatasetID: 1, Filename: "silent.mp4"}, pages, "")
+	if len(chunks) != 1 {
+		t.Fatalf("got %d chunks, want 1", len(chunks))
+	}
+	c := chunks[0]
+	if want := "[0:12] Slide: Title\n[1:35] Slide: Agenda"; c.Text != want {
+		t.Errorf("text = %q, want %q", c.Text, want)
+	}
+	if c.TimeStart != 12 || c.TimeEnd != 95 {
+		t.Errorf("time range = %v-%v, want 12-95", c.TimeStart, c.TimeEnd)
+	}
+}
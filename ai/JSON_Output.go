@@ -0,0 +1,98 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// JSONSchema is the subset of JSON Schema used to constrain LLM output
+type JSONSchema struct {
+	Type        string                 `json:"type"` // "object", "array", "string", "integer", "number" or "boolean"
+	Description string                 `json:"description,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+}
+
+// JSONGenerator is implemented by providers that can constrain their reply to a JSON schema
+type JSONGenerator interface {
+	GenerateJSON(ctx context.Context, prompt string, schema *JSONSchema) (string, error)
+}
+
+// generateJSON asks the provider for JSON matching the schema. Providers without
+// schema support get the schema in the prompt instead.
+func generateJSON(ctx context.Context, p LLMProvider, prompt string, schema *JSONSchema) (string, error) {
+	if g, ok := p.(JSONGenerator); ok {
+		return g.GenerateJSON(ctx, prompt, schema)
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return p.Generate(ctx, prompt+"\n\nReply with JSON only, matching this JSON Schema:\n"+string(data))
+}
+
+// extractJSON returns the JSON value in an LLM reply, without markdown fences or
+// text around it
+func extractJSON(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if i := strings.Index(reply, "```"); i >= 0 {
+		rest := reply[i+3:]
+		rest = strings.TrimPrefix(rest, "json")
+		if j := strings.Index(rest, "```"); j >= 0 {
+			reply = strings.TrimSpace(rest[:j])
+		}
+	}
+
+	start := strings.IndexAny(reply, "{[")
+	end := strings.LastIndexAny(reply, "}]")
+	if start < 0 || end < start {
+		return reply
+	}
+	return reply[start : end+1]
+}
+
+// toGenaiSchema converts a schema for Gemini's ResponseSchema
+func toGenaiSchema(s *JSONSchema) (*genai.Schema, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	types := map[string]genai.Type{
+		"object":  genai.TypeObject,
+		"array":   genai.TypeArray,
+		"string":  genai.TypeString,
+		"integer": genai.TypeInteger,
+		"number":  genai.TypeNumber,
+		"boolean": genai.TypeBoolean,
+	}
+	t, ok := types[s.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported schema type: %s", s.Type)
+	}
+
+	out := &genai.Schema{Type: t, Description: s.Description, Enum: s.Enum, Required: s.Required}
+	if len(s.Enum) > 0 {
+		out.Format = "enum"
+	}
+	items, err := toGenaiSchema(s.Items)
+	if err != nil {
+		return nil, err
+	}
+	out.Items = items
+	if len(s.Properties) > 0 {
+		out.Properties = map[string]*genai.Schema{}
+		for name, prop := range s.Properties {
+			if out.Properties[name], err = toGenaiSchema(prop); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
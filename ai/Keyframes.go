@@ -0,0 +1,138 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// SlideText is the text of a slide shown in a lecture recording, seconds into the video
+type SlideText struct {
+	Time float64 `json:"time"`
+	Text string  `json:"text"`
+}
+
+// keyframe is a frame ffmpeg picked at a scene change
+type keyframe struct {
+	Path string
+	Time float64
+}
+
+// showinfoTime matches the pts_time printed by ffmpeg's showinfo filter for each selected frame
+var showinfoTime = regexp.MustCompile(`Parsed_showinfo.*\spts_time:\s*([0-9.]+)`)
+
+// extractKeyframes saves the first frame and every scene change of the video as PNGs in dir
+func extractKeyframes(ctx context.Context, videoPath, dir string) ([]keyframe, error) {
+	threshold := envFloat("KEYFRAME_SCENE_THRESHOLD", 0.3)
+	filter := fmt.Sprintf("select='eq(n\\,0)+gt(scene\\,%g)',showinfo", threshold)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", videoPath, "-vf", filter, "-vsync", "vfr",
+		"-frames:v", strconv.Itoa(max(envInt("KEYFRAME_MAX", 200), 1)),
+		filepath.Join(dir, "frame-%04d.png"), "-y")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("keyframe extraction failed: %v", err)
+	}
+
+	// showinfo logs the selected frames in output order, matching the file numbering
+	var frames []keyframe
+	for _, m := range showinfoTime.FindAllStringSubmatch(stderr.String(), -1) {
+		t, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		frames = append(frames, keyframe{
+			Path: filepath.Join(dir, fmt.Sprintf("frame-%04d.png", len(frames)+1)),
+			Time: t,
+		})
+	}
+	return frames, nil
+}
+
+// slidesFromVideo OCRs the keyframes of a video, dropping frames without text and
+// consecutive frames showing the same slide
+func slidesFromVideo(ctx context.Context, ws *Workspace, videoPath string) ([]SlideText, error) {
+	dir, err := ws.Mkdir("keyframes")
+	if err != nil {
+		return nil, err
+	}
+
+	Emit(ctx, Event{Stage: StageKeyframes})
+	frames, err := extractKeyframes(ctx, videoPath, dir)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("OCR of %d keyframes...\n", len(frames))
+
+	texts := make([]string, len(frames))
+	var mu sync.Mutex
+	done := 0
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(envInt("KEYFRAME_WORKERS", 4), 1))
+	for i, frame := range frames {
+		g.Go(func() error {
+			text, err := detectText(gctx, frame.Path)
+			if err != nil {
+				return fmt.Errorf("keyframe at %s: %v", formatTimestamp(frame.Time), err)
+			}
+			texts[i] = strings.TrimSpace(text)
+
+			mu.Lock()
+			done++
+			Emit(ctx, Event{Stage: StageOCR, Page: done, Total: len(frames)})
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var slides []SlideText
+	previous := ""
+	for i, text := range texts {
+		normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+		if normalized == "" || normalized == previous {
+			continue
+		}
+		previous = normalized
+		slides = append(slides, SlideText{Time: frames[i].Time, Text: text})
+	}
+	return slides, nil
+}
+
+// attachSlides places each slide on the transcript section it was shown in; slides shown
+// during a pause in speech go to the following section, or the last one at the end
+func attachSlides(sections []PageData, slides []SlideText) []PageData {
+	if len(slides) == 0 {
+		return sections
+	}
+	if len(sections) == 0 {
+		// Nothing was said, so the slides are the whole content
+		return []PageData{{
+			Page:   1,
+			Start:  slides[0].Time,
+			End:    slides[len(slides)-1].Time,
+			Slides: slides,
+		}}
+	}
+
+	for _, slide := range slides {
+		i := 0
+		for i < len(sections)-1 && slide.Time >= sections[i].End {
+			i++
+		}
+		sections[i].Slides = append(sections[i].Slides, slide)
+	}
+	return sections
+}
@@ -0,0 +1,338 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// LLMProvider is a text generation backend used for cleanup, QA and exam prompts
+type LLMProvider interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+var (
+	llmMu sync.Mutex
+	llm   LLMProvider
+)
+
+// SetLLMProvider overrides the provider used by the package (e.g. in tests)
+func SetLLMProvider(p LLMProvider) {
+	llmMu.Lock()
+	defer llmMu.Unlock()
+	llm = p
+}
+
+// llmProvider returns the configured provider, building it from the environment on first use
+func llmProvider() (LLMProvider, error) {
+	llmMu.Lock()
+	defer llmMu.Unlock()
+	if llm != nil {
+		return llm, nil
+	}
+
+	p, err := NewLLMProvider()
+	if err != nil {
+		return nil, err
+	}
+	llm = p
+	return llm, nil
+}
+
+// NewLLMProvider builds a provider from LLM_PROVIDER ("gemini", "openai" or "fake")
+func NewLLMProvider() (LLMProvider, error) {
+	switch name := envOr("LLM_PROVIDER", "gemini"); name {
+	case "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY not set")
+		}
+		return &GeminiProvider{
+			APIKey: apiKey,
+			Model:  envOr("GEMINI_MODEL", "gemini-2.0-flash-001"),
+		}, nil
+
+	case "openai":
+		return &OpenAIProvider{
+			BaseURL: envOr("OPENAI_BASE_URL", "https://api.openai.com/v1"),
+			APIKey:  os.Getenv("OPENAI_API_KEY"),
+			Model:   envOr("OPENAI_MODEL", "gpt-4o-mini"),
+		}, nil
+
+	case "fake":
+		return &FakeProvider{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER: %s", name)
+	}
+}
+
+// generate sends the prompt to the configured provider
+func generate(ctx context.Context, prompt string) (string, error) {
+	p, err := llmProvider()
+	if err != nil {
+		return "", err
+	}
+	return p.Generate(ctx, prompt)
+}
+
+// =============== Gemini ===============
+
+// GeminiProvider talks to Google's Gemini API
+type GeminiProvider struct {
+	APIKey string
+	Model  string
+}
+
+func (g *GeminiProvider) Generate(ctx context.Context, prompt string) (string, error) {
+	// Create a new Gemini client using the API key
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g.APIKey))
+	if err != nil {
+		return "", fmt.Errorf("error creating client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(g.Model)
+
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", fmt.Errorf("error generating content: %w", err)
+	}
+
+	// Check if the response contains candidates and extract the text
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil && len(resp.Candidates[0].Content.Parts) > 0 {
+		text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+		if !ok {
+			return "", fmt.Errorf("unexpected response format: could not extract text")
+		}
+		return string(text), nil
+	}
+
+	return "", fmt.Errorf("no response content found")
+}
+
+func (g *GeminiProvider) GenerateJSON(ctx context.Context, prompt string, schema *JSONSchema) (string, error) {
+	responseSchema, err := toGenaiSchema(schema)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g.APIKey))
+	if err != nil {
+		return "", fmt.Errorf("error creating client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(g.Model)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = responseSchema
+
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", fmt.Errorf("error generating content: %w", err)
+	}
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil && len(resp.Candidates[0].Content.Parts) > 0 {
+		text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+		if !ok {
+			return "", fmt.Errorf("unexpected response format: could not extract text")
+		}
+		return string(text), nil
+	}
+
+	return "", fmt.Errorf("no response content found")
+}
+
+// =============== OpenAI-compatible ===============
+
+// OpenAIProvider talks to any server implementing the OpenAI chat completions API
+// (OpenAI itself, vLLM, llama.cpp server, Ollama, ...)
+type OpenAIProvider struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string `json:"type"` // "json_schema"
+	JSONSchema struct {
+		Name   string      `json:"name"`
+		Schema *JSONSchema `json:"schema"`
+	} `json:"json_schema"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (o *OpenAIProvider) Generate(ctx context.Context, prompt string) (string, error) {
+	return o.chat(ctx, openAIChatRequest{
+		Model:    o.Model,
+		Messages: []openAIMessage{{Role: "user", Content: prompt}},
+	})
+}
+
+func (o *OpenAIProvider) GenerateJSON(ctx context.Context, prompt string, schema *JSONSchema) (string, error) {
+	format := &openAIResponseFormat{Type: "json_schema"}
+	format.JSONSchema.Name = "response"
+	format.JSONSchema.Schema = schema
+
+	return o.chat(ctx, openAIChatRequest{
+		Model:          o.Model,
+		Messages:       []openAIMessage{{Role: "user", Content: prompt}},
+		ResponseFormat: format,
+	})
+}
+
+// chat sends a chat completion request and returns the first choice
+func (o *OpenAIProvider) chat(ctx context.Context, req openAIChatRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	var resp openAIChatResponse
+	if err := o.post(ctx, "/chat/completions", body, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no response content found")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// post sends a JSON request to the API and decodes the JSON response into out
+func (o *OpenAIProvider) post(ctx context.Context, path string, body []byte, out interface{}) error {
+	url := strings.TrimSuffix(o.BaseURL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %s: %w", url, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return &StatusError{URL: url, StatusCode: res.StatusCode, Status: res.Status, Body: strings.TrimSpace(string(data))}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("error parsing response: %v", err)
+	}
+	return nil
+}
+
+// StatusError is returned when an OpenAI-compatible server answers with an error status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned %s: %s", e.URL, e.Status, e.Body)
+}
+
+// =============== Fake ===============
+
+// FakeProvider is a deterministic offline backend for tests and local development.
+// By default it echoes everything after the first line of the prompt, which for the
+// cleanup prompts is the extracted text itself.
+type FakeProvider struct {
+	Reply func(prompt string) string
+	// JSONReply answers GenerateJSON, letting tests reply per schema
+	JSONReply func(prompt string, schema *JSONSchema) string
+}
+
+func (f *FakeProvider) Generate(ctx context.Context, prompt string) (string, error) {
+	if f.Reply != nil {
+		return f.Reply(prompt), nil
+	}
+	if i := strings.Index(prompt, "\n"); i >= 0 {
+		return strings.TrimSpace(prompt[i+1:]), nil
+	}
+	return prompt, nil
+}
+
+// GenerateJSON returns JSONReply's or else Reply's output when one is set. Otherwise
+// it returns a value with every required field filled with its zero value or first
+// enum value.
+func (f *FakeProvider) GenerateJSON(ctx context.Context, prompt string, schema *JSONSchema) (string, error) {
+	if f.JSONReply != nil {
+		return f.JSONReply(prompt, schema), nil
+	}
+	if f.Reply != nil {
+		return f.Reply(prompt), nil
+	}
+
+	data, err := json.Marshal(fakeValue(schema))
+	return string(data), err
+}
+
+// fakeValue returns a value matching the schema, built from zero values
+func fakeValue(s *JSONSchema) interface{} {
+	if s == nil {
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		obj := map[string]interface{}{}
+		for name, prop := range s.Properties {
+			obj[name] = fakeValue(prop)
+		}
+		return obj
+	case "array":
+		return []interface{}{}
+	case "string":
+		if len(s.Enum) > 0 {
+			return s.Enum[0]
+		}
+		return ""
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	}
+	return nil
+}
@@ -2,158 +2,101 @@ package ai
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
-
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 )
 
-// =============== Structs ===============
-type Content struct {
-	Content string `json:"content"`
-	Page    int    `json:"page,omitempty"`
+// =============== Core AI System ===============
+type QASystem struct {
+	user      *UserChunks
+	retriever Retriever
+	llm       LLMProvider
 }
 
-type Topic struct {
-	Topic   string    `json:"topic"`
-	Content []Content `json:"content,omitempty"`
+// NewQASystem answers questions from the user's datasets in the store
+func NewQASystem(s DatasetStore, userID int, r Retriever, llm LLMProvider) *QASystem {
+	return &QASystem{user: NewUserChunks(s, userID), retriever: r, llm: llm}
 }
 
-// For topics that sometimes use string instead of array
-func (t *Topic) UnmarshalJSON(data []byte) error {
-	type Alias Topic
-	var tmp Alias
-	if err := json.Unmarshal(data, &tmp); err == nil {
-		*t = Topic(tmp)
-		return nil
+// FindRelevantContent returns the RETRIEVAL_TOP_K chunks most relevant to the question.
+// If the retriever finds nothing, every chunk of the user's datasets is returned, in
+// order and unscored, for the context builder to cut down to its budget. It returns
+// ErrDatasetNotFound if the user has no content at all.
+func (qa *QASystem) FindRelevantContent(ctx context.Context, question string) ([]ScoredChunk, error) {
+	relevant, err := qa.retriever.Retrieve(ctx, qa.user, question, envInt("RETRIEVAL_TOP_K", 8))
+	if err != nil || len(relevant) > 0 {
+		return relevant, err
 	}
 
-	var alt struct {
-		Topic   string `json:"topic"`
-		Content string `json:"content"`
+	chunks, err := qa.user.Load(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(data, &alt); err != nil {
-		return err
+	if len(chunks) == 0 {
+		return nil, ErrDatasetNotFound
 	}
-	*t = Topic{
-		Topic: alt.Topic,
-		Content: []Content{
-			{Content: alt.Content},
-		},
+	all := make([]ScoredChunk, len(chunks))
+	for i, c := range chunks {
+		all[i] = ScoredChunk{Chunk: c}
 	}
-	return nil
+	return all, nil
 }
 
-// =============== Core AI System ===============
-type QASystem struct {
-	topics []Topic
-	apiKey string
-}
-
-// NewQASystem loads dataset into memory
-func NewQASystem(datasetPath, apiKey string) (*QASystem, error) {
-	data, err := os.ReadFile(datasetPath)
+// BuildContext ranks the relevant chunks and fits them into CONTEXT_TOKEN_BUDGET
+func (qa *QASystem) BuildContext(ctx context.Context, question string) (*BuiltContext, error) {
+	relevant, err := qa.FindRelevantContent(ctx, question)
 	if err != nil {
-		return nil, fmt.Errorf("error reading dataset: %v", err)
+		return nil, err
 	}
 
-	var topics []Topic
-	if err := json.Unmarshal(data, &topics); err != nil {
-		return nil, fmt.Errorf("error parsing dataset: %v", err)
+	built := newContextBuilder().Build(relevant)
+	if report := built.Report(); report != "" {
+		fmt.Println("Context budget:", report)
 	}
-
-	return &QASystem{topics: topics, apiKey: apiKey}, nil
+	return built, nil
 }
 
-// FindRelevantContent tries to match question to dataset topics
-func (qa *QASystem) FindRelevantContent(question string) []string {
-	questionLower := strings.ToLower(question)
-	var results []string
-
-	for _, topic := range qa.topics {
-		topicLower := strings.ToLower(strings.ReplaceAll(topic.Topic, "_", " "))
-
-		if strings.Contains(questionLower, topicLower) ||
-			strings.Contains(topicLower, questionLower) {
-			for _, c := range topic.Content {
-				results = append(results, c.Content)
-			}
-		}
-	}
-	return results
+// queryLLM sends the prompt to the configured LLM provider
+func (qa *QASystem) queryLLM(ctx context.Context, prompt string) (string, error) {
+	return qa.llm.Generate(ctx, prompt)
 }
 
-// queryGemini calls Gemini API
-func (qa *QASystem) queryGemini(prompt string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(qa.apiKey))
-	if err != nil {
-		return "", err
-	}
-	defer client.Close()
-
-	model := client.GenerativeModel("gemini-2.0-flash-001")
+// =============== Public Entry ===============
 
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+// AI is the entrypoint for handlers answering a question. In "qa" mode the answer
+// cites the chunks it is based on. Exams are generated by GenerateExam.
+// mode = "qa" | "transform"
+func AI(ctx context.Context, mode, question string, userID int) (*Answer, error) {
+	llm, err := llmProvider()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		if text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
-			return string(text), nil
-		}
+	s, err := datasetStore()
+	if err != nil {
+		return nil, err
 	}
-	return "", fmt.Errorf("empty response from Gemini")
-}
-
-// =============== Public Entry ===============
-
-// AI is the single entrypoint for handlers
-// mode = "qa" | "exam" | "transform"
-func AI(mode, question, datasetPath string) (string, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("GEMINI_API_KEY not set")
+	r, err := chunkRetriever()
+	if err != nil {
+		return nil, err
 	}
 
-	qa, err := NewQASystem(datasetPath, apiKey)
+	qa := NewQASystem(s, userID, r, llm)
+	built, err := qa.BuildContext(ctx, question)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	contextData := qa.FindRelevantContent(question)
-	contextStr := strings.Join(contextData, "\n\n")
+	contextStr := built.Text
 
 	var prompt string
 	switch mode {
 	case "qa":
 		prompt = fmt.Sprintf(
 			"You are a helpful assistant. Answer ONLY from context.\n"+
-				"If no info is found, reply: 'I don't have enough information to answer that question.'\n\n"+
+				"If no info is found, reply: 'I don't have enough information to answer that question.'\n"+
+				"Each part of the context starts with its ID, like [chunk:12-3 | ...]. After every statement, "+
+				"cite the parts it comes from as [chunk:<id>], e.g. [chunk:12-3]. Only cite IDs from the context.\n\n"+
 				"Context:\n%s\n\nQuestion: %s",
 			contextStr, question)
 
-	case "exam":
-		// Example: question = "topic=Work, count=5, difficulty=medium"
-		prompt = fmt.Sprintf(
-			"You are an exam question generator.\n"+
-				"Using the provided dataset, generate unique questions along with the answers.\n"+
-				"For each question: \n" +
-				"Make sure it is relevant to the topic and difficulty specified. \n" +
-				"Provide a clear, correct answer immediately after the question. \n" +
-				"Do not include any additional explanation or commentary. \n" +
-				"%s\n\nContext:\n%s"+
-				"Format: \n" +
-				"**Question <question number>:** \n" +
-				"<Question Text> \n" +
-				"**Answer <answer number>:** \n" +
-				"<Answer Text>",
-			question, contextStr)
-
 	case "transform":
 		// Example: question = "Evaluate the integral: ∫ 6x² cos(2x³+1) dx."
 		prompt = fmt.Sprintf(
@@ -171,8 +114,54 @@ func AI(mode, question, datasetPath string) (string, error) {
 			question)
 
 	default:
-		return "", fmt.Errorf("invalid mode: %s", mode)
+		return nil, fmt.Errorf("invalid mode: %s", mode)
+	}
+
+	reply, err := qa.queryLLM(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "qa" {
+		return citeAnswer(reply, built.Chunks), nil
+	}
+	return &Answer{Text: reply, Citations: []Citation{}}, nil
+}
+
+// GenerateExam generates exam questions from the user's datasets
+func GenerateExam(ctx context.Context, userID int, req *ExamRequest) ([]ExamQuestion, error) {
+	llm, err := llmProvider()
+	if err != nil {
+		return nil, err
+	}
+	s, err := datasetStore()
+	if err != nil {
+		return nil, err
+	}
+	r, err := chunkRetriever()
+	if err != nil {
+		return nil, err
+	}
+
+	qa := NewQASystem(s, userID, r, llm)
+	qa.user.DatasetIDs = req.DatasetIDs
+	built, err := qa.BuildContext(ctx, req.query())
+	if err != nil {
+		return nil, err
 	}
 
-	return qa.queryGemini(prompt)
+	prompt := fmt.Sprintf(
+		"You are an exam question generator.\n"+
+			"Using the provided dataset, generate unique questions along with the answers.\n"+
+			"For each question: \n"+
+			"Make sure it is relevant to the topics and has the difficulty specified. \n"+
+			"Give its type (multiple_choice, true_false or short_answer), the options for multiple choice "+
+			"and true/false questions, the correct answer (for multiple choice, the text of the correct option), "+
+			"a one sentence explanation and its difficulty (easy, medium or hard). \n"+
+			"Each part of the context starts with its ID, like [chunk:12-3 | ...]; set source_chunk to the ID "+
+			"of the part the question is based on, e.g. 12-3. \n"+
+			"%s\n\nContext:\n%s\n\n"+
+			"Reply with a JSON object whose \"questions\" array holds the questions.",
+		req.instructions(), built.Text)
+
+	return generateExam(ctx, llm, prompt, req, built.Chunks)
 }
@@ -0,0 +1,121 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// legacyTopic is an entry of the dataset format written before chunks: a JSON array
+// of topics whose content is either a list of pages or, for images, a plain string
+type legacyTopic struct {
+	Topic   string          `json:"topic"`
+	Content json.RawMessage `json:"content"`
+}
+
+// isLegacyDataset reports whether data is in the legacy topic format rather than JSON Lines
+func isLegacyDataset(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+}
+
+// migrateLegacyDataset converts a legacy topic dataset into chunks. Legacy topics
+// carry no dataset ID, so their chunks get dataset ID 0 and a "legacy-" chunk ID.
+func migrateLegacyDataset(data []byte) ([]Chunk, error) {
+	var topics []legacyTopic
+	if err := json.Unmarshal(data, &topics); err != nil {
+		return nil, fmt.Errorf("error parsing legacy dataset: %v", err)
+	}
+
+	var chunks []Chunk
+	for t, topic := range topics {
+		var pages []PageData
+		if err := json.Unmarshal(topic.Content, &pages); err != nil {
+			var text string
+			if err := json.Unmarshal(topic.Content, &text); err != nil {
+				return nil, fmt.Errorf("error parsing legacy topic %q: %v", topic.Topic, err)
+			}
+			pages = []PageData{{Page: 1, Text: text}}
+		}
+
+		var headings []string
+		if title := strings.ReplaceAll(topic.Topic, "_", " "); title != "" {
+			headings = []string{title}
+		}
+		for c, p := range pages {
+			text := pageText(p)
+			if text == "" {
+				continue
+			}
+			chunks = append(chunks, Chunk{
+				Version:     ChunkSchemaVersion,
+				ChunkID:     fmt.Sprintf("legacy-%d-%d", t+1, c+1),
+				Source:      topic.Topic,
+				PageStart:   p.Page,
+				PageEnd:     p.Page,
+				TimeStart:   p.Start,
+				TimeEnd:     p.End,
+				HeadingPath: headings,
+				Text:        text,
+				Hash:        hashText(text),
+			})
+		}
+	}
+	return chunks, nil
+}
+
+// MigrateDatasetFile rewrites a legacy topic dataset file as chunks. It reports
+// whether the file was migrated; files already in the chunk format are left alone.
+func MigrateDatasetFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if !isLegacyDataset(data) {
+		return false, nil
+	}
+
+	chunks, err := migrateLegacyDataset(data)
+	if err != nil {
+		// Interrupted rewrites of the legacy format could leave the file truncated
+		return true, recoverDatasetFile(path, nil, &CorruptDatasetError{Path: path, Err: err})
+	}
+	out, err := encodeChunks(chunks)
+	if err != nil {
+		return false, err
+	}
+
+	// Keep the original next to the migrated file in case anything needs recovering
+	if err := os.WriteFile(path+".legacy", data, 0644); err != nil {
+		return false, err
+	}
+	return true, writeFileAtomic(path, out)
+}
+
+// MigrateDatasets migrates every dataset.jsonl under root to the chunk format
+func MigrateDatasets(root string) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != "dataset.jsonl" {
+			return nil
+		}
+
+		migrated, err := MigrateDatasetFile(path)
+		if err != nil {
+			return fmt.Errorf("migrating %s: %v", path, err)
+		}
+		if migrated {
+			fmt.Println("Migrated dataset to chunk schema:", path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	vision "cloud.google.com/go/vision/apiv1"
+)
+
+// OCREngine extracts the raw text from an image file
+type OCREngine interface {
+	DetectText(ctx context.Context, file string) (string, error)
+}
+
+var (
+	ocrMu sync.Mutex
+	ocr   OCREngine
+)
+
+// SetOCREngine overrides the OCR engine used by the package (e.g. in tests)
+func SetOCREngine(e OCREngine) {
+	ocrMu.Lock()
+	defer ocrMu.Unlock()
+	ocr = e
+}
+
+// ocrEngine returns the configured engine, building it from the environment on first use
+func ocrEngine() (OCREngine, error) {
+	ocrMu.Lock()
+	defer ocrMu.Unlock()
+	if ocr != nil {
+		return ocr, nil
+	}
+
+	e, err := NewOCREngine()
+	if err != nil {
+		return nil, err
+	}
+	ocr = e
+	return ocr, nil
+}
+
+// NewOCREngine builds an engine from OCR_ENGINE ("vision" or "tesseract")
+func NewOCREngine() (OCREngine, error) {
+	switch name := envOr("OCR_ENGINE", "vision"); name {
+	case "vision":
+		return &VisionOCR{}, nil
+	case "tesseract":
+		return &TesseractOCR{
+			Bin:  envOr("TESSERACT_BIN", "tesseract"),
+			Lang: envOr("TESSERACT_LANG", "eng"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown OCR_ENGINE: %s", name)
+	}
+}
+
+// detectText runs the configured OCR engine on the image
+func detectText(ctx context.Context, file string) (string, error) {
+	e, err := ocrEngine()
+	if err != nil {
+		return "", err
+	}
+	return e.DetectText(ctx, file)
+}
+
+// =============== Google Vision ===============
+
+// VisionOCR uses Google Cloud Vision document text detection
+type VisionOCR struct{}
+
+func (v *VisionOCR) DetectText(ctx context.Context, file string) (string, error) {
+	client, err := vision.NewImageAnnotatorClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	image, err := vision.NewImageFromReader(f)
+	if err != nil {
+		return "", err
+	}
+	annotation, err := client.DetectDocumentText(ctx, image, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if annotation == nil {
+		return "", nil
+	}
+	return annotation.Text, nil
+}
+
+// =============== Tesseract ===============
+
+// TesseractOCR runs the local tesseract binary, so no material leaves the server
+type TesseractOCR struct {
+	Bin  string
+	Lang string
+}
+
+func (t *TesseractOCR) DetectText(ctx context.Context, file string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Bin, file, "stdout", "-l", t.Lang)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
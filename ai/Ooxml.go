@@ -0,0 +1,86 @@
+package ai
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Helpers shared by the DOCX and PPTX extractors. Both formats are zip archives
+// of XML parts (Office Open XML) linked together by relationship files.
+
+// readZipPart returns the contents of a part of the archive
+func readZipPart(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+// hasZipPart reports whether the archive contains the part
+func hasZipPart(zr *zip.Reader, name string) bool {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type ooxmlRelationship struct {
+	ID     string `xml:"Id,attr"`
+	Type   string `xml:"Type,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+// readRelationships parses the .rels file of a part and resolves the targets to archive paths
+func readRelationships(zr *zip.Reader, part string) (map[string]ooxmlRelationship, error) {
+	dir, file := path.Split(part)
+	relsName := path.Join(dir, "_rels", file+".rels")
+	if !hasZipPart(zr, relsName) {
+		return map[string]ooxmlRelationship{}, nil
+	}
+
+	data, err := readZipPart(zr, relsName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rels struct {
+		Relationships []ooxmlRelationship `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", relsName, err)
+	}
+
+	byID := map[string]ooxmlRelationship{}
+	for _, r := range rels.Relationships {
+		if strings.HasPrefix(r.Target, "/") {
+			r.Target = strings.TrimPrefix(r.Target, "/")
+		} else {
+			r.Target = path.Join(dir, r.Target)
+		}
+		byID[r.ID] = r
+	}
+	return byID, nil
+}
+
+// xmlAttr returns the value of the attribute with the given local name
+func xmlAttr(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
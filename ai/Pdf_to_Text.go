@@ -4,146 +4,239 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os/exec"
-	"io/fs"
-	"os"
 	"path/filepath"
-
-	vision "cloud.google.com/go/vision/apiv1"
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func PdfToText(pdfFilePath string) ([]PageData, error) {
+func PdfToText(ctx context.Context, pdfFilePath string) ([]PageData, error) {
 	// Setup the directory where PNGs will be saved
-	outputDir := fmt.Sprintf("%s_images", pdfFilePath[:len(pdfFilePath)-len(".pdf")])
-	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-		os.Mkdir(outputDir, os.ModePerm)
+	ws, cleanup, err := ingestWorkspace(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer cleanup()
 
-	// Step 1: Convert the pdf's pages to images
-	fmt.Println("Converting the pdf's pages to images")
-	extractPDFPagesAsImages(pdfFilePath, outputDir)
+	outputDir, err := ws.Mkdir("pages")
+	if err != nil {
+		return nil, err
+	}
 
-	finalText := ""
+	// Step 1: Find out how many pages the pdf has
+	Emit(ctx, Event{Stage: StageConverting})
+	pageCount, err := pdfPageCount(ctx, pdfFilePath)
+	if err != nil {
+		return nil, err
+	}
 
-	// Step 2: Extract the text from each image
-	fmt.Println("Extracting text from each image and sending it to gemini for cleanup")
-	var pages []PageData
-	pageNo := 1
-	err := filepath.WalkDir(outputDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			extractedText, err := imgToText(path)
-			if err != nil {
+	// Step 2: Extract the text of each page, several pages at a time
+	fmt.Println("Extracting text from each page and sending it to the LLM for cleanup")
+	pages := make([]PageData, pageCount)
+	retries := max(envInt("PDF_PAGE_RETRIES", 2), 0)
+
+	var mu sync.Mutex
+	done := 0
+	reportProgress(ctx, 0, pageCount)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(envInt("PDF_WORKERS", 4), 1))
+	for i := range pageCount {
+		page := i + 1
+		g.Go(func() error {
+			var finalText string
+			err := withRetry(gctx, retries, func() error {
+				var err error
+				finalText, err = processPDFPage(gctx, pdfFilePath, outputDir, page, pageCount)
 				return err
-			}
-
-			// Step 3: Send the extracted text to gemini for cleanup
-			finalText, err = pdfSendToGemini(extractedText)
+			})
 			if err != nil {
-				return err
-			} else {
-				pages = append(pages, PageData{Page: pageNo, Text: finalText})
-				pageNo += 1
+				return fmt.Errorf("page %d: %v", page, err)
 			}
-		}
-		return nil
-	})
-	if err != nil {
+			pages[i] = PageData{Page: page, Text: finalText}
+
+			mu.Lock()
+			done++
+			reportProgress(ctx, done, pageCount)
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
 		return nil, err
 	}
 
 	return pages, nil
 }
 
-// Use gemini to cleanup the extrcated text
-func pdfSendToGemini(text string) (string, error) {
-	ctx := context.Background()
-	apiKey := os.Getenv("GEMINI_API_KEY")
+// processPDFPage extracts and cleans up the text of a single page. Born-digital pages
+// use their text layer; only scanned pages are rendered and sent through OCR.
+func processPDFPage(ctx context.Context, pdfPath, outputDir string, page, total int) (string, error) {
+	extractedText := ""
+	if envOr("PDF_TEXT_LAYER", "on") != "off" {
+		Emit(ctx, Event{Stage: StageTextLayer, Page: page, Total: total})
+		text, err := pdfPageText(ctx, pdfPath, page)
+		if err != nil {
+			return "", err
+		}
+		if hasUsableText(text, envInt("PDF_MIN_TEXT_CHARS", 50)) {
+			extractedText = text
+		}
+	}
 
-	// Create a new Gemini client using the API key
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		return "", fmt.Errorf("error creating client: %v", err)
+	if extractedText == "" {
+		Emit(ctx, Event{Stage: StageOCR, Page: page, Total: total})
+		image, err := renderPDFPage(ctx, pdfPath, outputDir, page)
+		if err != nil {
+			return "", err
+		}
+		extractedText, err = imgToText(ctx, image)
+		if err != nil {
+			return "", err
+		}
+		// A blank scanned page, such as the back of a cover, is an empty page
+		if extractedText == "" {
+			return "", nil
+		}
 	}
-	defer client.Close()
 
-	// Specify the model
-	model := client.GenerativeModel("gemini-2.0-flash-001")
+	// Step 3: Send the extracted text to the LLM for cleanup
+	Emit(ctx, Event{Stage: StageCleanup, Page: page, Total: total})
+	return pdfSendToLLM(ctx, extractedText)
+}
+
+// pdfPageCountLine matches the "Pages:" line printed by pdfinfo
+var pdfPageCountLine = regexp.MustCompile(`(?m)^Pages:\s+(\d+)`)
 
-	// Create the prompt for summarization
-	prompt := "Analyze the pdf and return the contents of the pdf in normal text format. Add double star for heading, single star for subheading, etc beautify the output a bit. Clean the text a bit like make the equations look good, etc. Read all the equations properly and solve them if unsolved. Do not summarize it and do not add etra texts like Here is the output, etc and show all the contents. If the formatted text is perfect then just return the text\n" + text
+// pdfPageCount reads the number of pages from pdfinfo
+func pdfPageCount(ctx context.Context, pdfPath string) (int, error) {
+	out, err := exec.CommandContext(ctx, "pdfinfo", pdfPath).Output()
+	if err != nil {
+		return 0, fmt.Errorf("pdfinfo failed: %v", err)
+	}
+	m := pdfPageCountLine.FindSubmatch(out)
+	if m == nil {
+		return 0, errors.New("pdfinfo did not report a page count")
+	}
+	return strconv.Atoi(string(m[1]))
+}
 
-	// Generate the content
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+// pdfPageText returns the text layer of a single page using pdftotext
+func pdfPageText(ctx context.Context, pdfPath string, page int) (string, error) {
+	p := strconv.Itoa(page)
+	out, err := exec.CommandContext(ctx, "pdftotext", "-f", p, "-l", p, "-layout", "-enc", "UTF-8", pdfPath, "-").Output()
 	if err != nil {
-		return "", fmt.Errorf("error generating content: %v", err)
+		return "", fmt.Errorf("pdftotext failed: %v", err)
 	}
+	return strings.TrimSpace(string(out)), nil
+}
 
-	// Check if the response contains candidates and extract the summarized text
-	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		summary, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
-		if ok {
-			// Return the summarized text
-			return string(summary), nil
-		} else {
-			return "", fmt.Errorf("unexpected response format: could not extract text")
+// hasUsableText reports whether a text layer is worth using instead of OCR: it needs
+// enough letters and digits, and not too many undecodable glyphs from broken font encodings
+func hasUsableText(text string, minChars int) bool {
+	alnum, broken := 0, 0
+	for _, r := range text {
+		switch {
+		case r == utf8.RuneError:
+			broken++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			alnum++
 		}
 	}
+	return alnum >= minChars && broken*10 < alnum
+}
 
-	// Return an error if no response content is found
-	return "", fmt.Errorf("no response content found")
+// withRetry calls fn until it succeeds, retrying transient errors up to retries times
+// with exponential backoff
+func withRetry(ctx context.Context, retries int, fn func() error) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		fmt.Printf("Attempt %d failed, retrying in %v: %v\n", attempt+1, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
 }
 
-// Convert each page of a PDF into a PNG image.
-func extractPDFPagesAsImages(pdfPath string, outputDir string) error {
-	fmt.Println("Converting PDF to images...")
-	cmd := exec.Command("pdftoppm", "-png", pdfPath, filepath.Join(outputDir, "page"))
-	
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Error converting PDF to images: %v\n", err)
-		return err
+// isTransient reports whether err may go away on its own: network failures, timeouts,
+// rate limits and server errors of the LLM and OCR APIs. Anything else, like a page the
+// PDF tools can't read, fails the same way again.
+func isTransient(err error) bool {
+	var statusErr *StatusError
+	var apiErr *googleapi.Error
+	var urlErr *url.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode == http.StatusRequestTimeout || statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	case errors.As(err, &apiErr):
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		return true
 	}
 
-	return nil
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted, codes.Internal:
+			return true
+		}
+	}
+	return false
 }
 
-// Extract the text from image
-func imgToText(file string) (string, error) {
+// Use the LLM to cleanup the extrcated text
+func pdfSendToLLM(ctx context.Context, text string) (string, error) {
+	// Create the prompt for cleanup
+	prompt := "Analyze the pdf and return the contents of the pdf in normal text format. Add double star for heading, single star for subheading, etc beautify the output a bit. Clean the text a bit like make the equations look good, etc. Read all the equations properly and solve them if unsolved. Do not summarize it and do not add etra texts like Here is the output, etc and show all the contents. If the formatted text is perfect then just return the text\n" + text
 
-	ctx := context.Background()
+	return generate(ctx, prompt)
+}
 
-	client, err := vision.NewImageAnnotatorClient(ctx)
-	if err != nil {
-		return "", err
-	}
+// Render a single page of a PDF into a PNG image for OCR.
+func renderPDFPage(ctx context.Context, pdfPath string, outputDir string, page int) (string, error) {
+	p := strconv.Itoa(page)
+	prefix := filepath.Join(outputDir, "page-"+p)
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-r", "200", "-f", p, "-l", p, "-singlefile", pdfPath, prefix)
 
-	f, err := os.Open(file)
-	if err != nil {
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Error converting PDF page %d to image: %v\n", page, err)
 		return "", err
 	}
-	defer f.Close()
 
-	image, err := vision.NewImageFromReader(f)
-	if err != nil {
-		return "", err
-	}
-	annotation, err := client.DetectDocumentText(ctx, image, nil)
-	if err != nil {
+	return prefix + ".png", nil
+}
+
+// Extract the text from image. An image without text gives an empty string.
+func imgToText(ctx context.Context, file string) (string, error) {
+	text, err := detectText(ctx, file)
+	if err != nil || strings.TrimSpace(text) == "" {
 		return "", err
 	}
 
-	if annotation == nil {
-		return "", errors.New("no text found")
-	} else {
-		cleanOutput, err := imgSendToGemini(annotation.Text)
-		if err != nil {
-			return "", err
-		} else {
-			return cleanOutput, nil
-		}
-	}
+	return imgSendToLLM(ctx, text)
 }
@@ -0,0 +1,66 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"server error", fmt.Errorf("cleanup: %w", &StatusError{StatusCode: http.StatusBadGateway}), true},
+		{"bad request", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"gemini overloaded", fmt.Errorf("error generating content: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}), true},
+		{"gemini bad key", &googleapi.Error{Code: http.StatusForbidden}, false},
+		{"vision unavailable", status.Error(codes.Unavailable, "try again"), true},
+		{"vision bad image", status.Error(codes.InvalidArgument, "bad image"), false},
+		{"timeout", fmt.Errorf("tesseract: %w", context.DeadlineExceeded), true},
+		{"cancelled", context.Canceled, false},
+		{"pdf tool", &exec.ExitError{}, false},
+		{"no text", errors.New("no text found"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: isTransient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithRetryStopsOnPermanentErrors(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), 3, func() error {
+		calls++
+		return errors.New("pdftoppm failed")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want the error after 1 call", err, calls)
+	}
+}
+
+type blankOCR struct{}
+
+func (blankOCR) DetectText(ctx context.Context, file string) (string, error) {
+	return " \n", nil
+}
+
+func TestImgToTextBlankPage(t *testing.T) {
+	SetOCREngine(blankOCR{})
+	defer SetOCREngine(nil)
+
+	text, err := imgToText(context.Background(), "blank.png")
+	if err != nil || text != "" {
+		t.Errorf("imgToText = %q, %v; want an empty page", text, err)
+	}
+}
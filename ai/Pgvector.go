@@ -0,0 +1,91 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/edubank/db"
+)
+
+// pgvectorDims is the size of the embedding_vec column (migrations/007_pgvector.sql)
+const pgvectorDims = 768
+
+// PgvectorRetriever runs the cosine similarity search in Postgres through the HNSW
+// index on dataset_chunks.embedding_vec, so only the top k chunks leave the database.
+// It needs DATASET_STORE=postgres, pgvector 0.8 or later and an embedder producing
+// 768-dimensional vectors.
+type PgvectorRetriever struct {
+	Embedder Embedder
+}
+
+func (p *PgvectorRetriever) Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error) {
+	query, err := p.Embedder.EmbedQuery(ctx, question)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding question: %v", err)
+	}
+	if len(query) != pgvectorDims {
+		return nil, fmt.Errorf("pgvector needs %d-dimensional embeddings, %s returned %d", pgvectorDims, p.Embedder.Name(), len(query))
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Rows of other users and datasets are filtered out while the index is scanned, so
+	// keep scanning (pgvector 0.8+) until k rows pass the filter instead of returning
+	// whatever survived the first ef_search candidates
+	settings := []string{
+		fmt.Sprintf("SET LOCAL hnsw.ef_search = %d", max(envInt("PGVECTOR_EF_SEARCH", 100), k)),
+		"SET LOCAL hnsw.iterative_scan = relaxed_order",
+		fmt.Sprintf("SET LOCAL hnsw.max_scan_tuples = %d", max(envInt("PGVECTOR_MAX_SCAN_TUPLES", 20000), 1)),
+	}
+	for _, stmt := range settings {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	// relaxed_order can return rows slightly out of order, so they are sorted again
+	rows, err := tx.Query(ctx,
+		"WITH nearest AS MATERIALIZED ("+
+			"SELECT "+chunkColumns+", c.embedding_vec <=> $2::vector AS distance "+
+			"FROM dataset_chunks c JOIN datasets d ON d.id = c.dataset_id "+
+			"WHERE d.user_id=$1 AND c.embedding_model=$3 AND c.embedding_vec IS NOT NULL "+
+			"AND (cardinality($5::int[]) = 0 OR c.dataset_id = ANY($5::int[])) "+
+			"ORDER BY distance LIMIT $4"+
+			") SELECT *, 1 - distance FROM nearest ORDER BY distance",
+		user.UserID, vectorLiteral(query), p.Embedder.Name(), k, append([]int{}, user.DatasetIDs...))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ScoredChunk
+	for rows.Next() {
+		var sc ScoredChunk
+		var distance float64
+		if err := rows.Scan(append(chunkFields(&sc.Chunk), &distance, &sc.Score)...); err != nil {
+			return nil, err
+		}
+		results = append(results, sc)
+	}
+	return results, rows.Err()
+}
+
+// vectorLiteral renders v in pgvector's text format, e.g. [0.1,-0.2,0.3]
+func vectorLiteral(v []float32) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
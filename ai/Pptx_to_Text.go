@@ -0,0 +1,211 @@
+package ai
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PptxToText extracts the text of a PowerPoint deck slide by slide, in presentation
+// order, with the slide title as a heading and the speaker notes appended.
+func PptxToText(ctx context.Context, pptxFilePath string) ([]PageData, error) {
+	fmt.Println("Extracting text from the slides")
+	Emit(ctx, Event{Stage: StageConverting})
+
+	zr, err := zip.OpenReader(pptxFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening pptx: %v", err)
+	}
+	defer zr.Close()
+
+	slides, err := pptxSlideParts(&zr.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []PageData
+	for i, slidePart := range slides {
+		text, err := pptxSlideText(&zr.Reader, slidePart)
+		if err != nil {
+			return nil, fmt.Errorf("slide %d: %v", i+1, err)
+		}
+		if text != "" {
+			pages = append(pages, PageData{Page: i + 1, Text: text})
+		}
+		reportProgress(ctx, i+1, len(slides))
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("no text found")
+	}
+
+	return pages, nil
+}
+
+// pptxSlideParts lists the slide parts in the order they appear in the presentation
+func pptxSlideParts(zr *zip.Reader) ([]string, error) {
+	const presentation = "ppt/presentation.xml"
+	data, err := readZipPart(zr, presentation)
+	if err != nil {
+		return nil, err
+	}
+	rels, err := readRelationships(zr, presentation)
+	if err != nil {
+		return nil, err
+	}
+
+	var pres struct {
+		Slides []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	if err := xml.Unmarshal(data, &pres); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", presentation, err)
+	}
+
+	var parts []string
+	for _, s := range pres.Slides {
+		if rel, ok := rels[s.RelID]; ok {
+			parts = append(parts, rel.Target)
+		}
+	}
+	return parts, nil
+}
+
+// pptxSlideText renders a slide as its title, body text and speaker notes
+func pptxSlideText(zr *zip.Reader, slidePart string) (string, error) {
+	data, err := readZipPart(zr, slidePart)
+	if err != nil {
+		return "", err
+	}
+	shapes, err := parsePptxShapes(data)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, sh := range shapes {
+		if len(sh.paragraphs) == 0 {
+			continue
+		}
+		if sh.placeholder == "title" || sh.placeholder == "ctrTitle" {
+			sb.WriteString("**" + strings.Join(sh.paragraphs, " ") + "**\n")
+		} else {
+			sb.WriteString(strings.Join(sh.paragraphs, "\n") + "\n")
+		}
+	}
+
+	notes, err := pptxNotesText(zr, slidePart)
+	if err != nil {
+		return "", err
+	}
+	if notes != "" {
+		sb.WriteString("\n*Speaker notes*\n" + notes + "\n")
+	}
+
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// pptxNotesText returns the speaker notes attached to a slide, if any
+func pptxNotesText(zr *zip.Reader, slidePart string) (string, error) {
+	rels, err := readRelationships(zr, slidePart)
+	if err != nil {
+		return "", err
+	}
+
+	for _, rel := range rels {
+		if !strings.HasSuffix(rel.Type, "/notesSlide") {
+			continue
+		}
+		data, err := readZipPart(zr, rel.Target)
+		if err != nil {
+			return "", err
+		}
+		shapes, err := parsePptxShapes(data)
+		if err != nil {
+			return "", err
+		}
+
+		// The notes page also holds the slide thumbnail and slide number; only the body is the notes
+		var notes []string
+		for _, sh := range shapes {
+			if sh.placeholder == "body" {
+				notes = append(notes, sh.paragraphs...)
+			}
+		}
+		return strings.Join(notes, "\n"), nil
+	}
+	return "", nil
+}
+
+// pptxShape is a text-bearing shape on a slide
+type pptxShape struct {
+	placeholder string // placeholder type, e.g. "title" or "body"
+	paragraphs  []string
+}
+
+// parsePptxShapes collects the text of each shape in a slide or notes part
+func parsePptxShapes(data []byte) ([]pptxShape, error) {
+	var shapes []pptxShape
+	var current *pptxShape
+	var para strings.Builder
+	inText := false
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing slide: %v", err)
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "sp", "graphicFrame": // graphic frames hold tables
+				current = &pptxShape{}
+			case "ph":
+				if current != nil {
+					current.placeholder = xmlAttr(el, "type")
+					if current.placeholder == "" {
+						current.placeholder = "body" // untyped placeholders are body text
+					}
+				}
+			case "p":
+				para.Reset()
+			case "t":
+				inText = true
+			case "br":
+				para.WriteString(" ")
+			}
+
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if text := strings.TrimSpace(para.String()); text != "" && current != nil {
+					current.paragraphs = append(current.paragraphs, text)
+				}
+			case "sp", "graphicFrame":
+				if current != nil {
+					shapes = append(shapes, *current)
+				}
+				current = nil
+			}
+
+		case xml.CharData:
+			if inText {
+				para.Write(el)
+			}
+		}
+	}
+
+	return shapes, nil
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+	"context"
+	"time"
+)
+
+// ProgressFunc receives how many units (pages, audio chunks) of an ingestion are finished
+type ProgressFunc func(done, total int)
+
+// Stages of the ingestion pipeline reported through Event
+const (
+	StageQueued          = "queued"
+	StageConverting      = "converting"
+	StageTextLayer       = "text_layer"
+	StageOCR             = "ocr"
+	StageExtractingAudio = "extracting_audio"
+	StageTranscribing    = "transcribing"
+	StageKeyframes       = "keyframes"
+	StageCleanup         = "cleanup"
+	StageFormatting      = "formatting"
+	StageEmbedding       = "embedding"
+	StageDone            = "done"
+	StageFailed          = "failed"
+)
+
+// Event is a stage transition of the ingestion pipeline, e.g. "OCR page 3 of 12"
+type Event struct {
+	Stage   string    `json:"stage"`
+	Page    int       `json:"page,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// EventFunc receives the events emitted while a file is ingested
+type EventFunc func(Event)
+
+type progressKey struct{}
+type eventKey struct{}
+
+// WithProgress returns a context whose ingestion steps report progress to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress calls the ProgressFunc attached to ctx, if any
+func reportProgress(ctx context.Context, done, total int) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(done, total)
+	}
+}
+
+// WithEvents returns a context whose ingestion steps emit their stage transitions to fn
+func WithEvents(ctx context.Context, fn EventFunc) context.Context {
+	return context.WithValue(ctx, eventKey{}, fn)
+}
+
+// Emit sends ev to the EventFunc attached to ctx, if any
+func Emit(ctx context.Context, ev Event) {
+	if fn, ok := ctx.Value(eventKey{}).(EventFunc); ok {
+		if ev.Time.IsZero() {
+			ev.Time = time.Now()
+		}
+		fn(ev)
+	}
+}
@@ -0,0 +1,324 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ScoredChunk is a chunk picked by a retriever, with its relevance to the question
+type ScoredChunk struct {
+	Chunk
+	Score float64
+}
+
+// Retriever picks the chunks of a user's datasets that are relevant to a question
+type Retriever interface {
+	// Retrieve returns up to k of the user's chunks, most relevant first
+	Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error)
+}
+
+// UserChunks gives retrievers access to a user's chunks. The chunks are only loaded
+// from the dataset store if a retriever asks for them, once per question, so retrievers
+// with their own index (pgvector) never hold a whole dataset in memory.
+type UserChunks struct {
+	UserID     int
+	DatasetIDs []int // when set, only chunks of these datasets are retrieved
+
+	store DatasetStore
+	once  sync.Once
+	all   []Chunk
+	err   error
+}
+
+// NewUserChunks returns the chunks of a user's datasets in the store
+func NewUserChunks(s DatasetStore, userID int) *UserChunks {
+	return &UserChunks{UserID: userID, store: s}
+}
+
+// LoadAll returns all of the user's chunks, ignoring DatasetIDs
+func (u *UserChunks) LoadAll(ctx context.Context) ([]Chunk, error) {
+	u.once.Do(func() {
+		u.all, u.err = u.store.LoadUser(ctx, u.UserID)
+		if u.err != nil {
+			u.err = fmt.Errorf("error loading dataset: %v", u.err)
+		}
+	})
+	return u.all, u.err
+}
+
+// Load returns the user's chunks that may be retrieved
+func (u *UserChunks) Load(ctx context.Context) ([]Chunk, error) {
+	all, err := u.LoadAll(ctx)
+	if err != nil || len(u.DatasetIDs) == 0 {
+		return all, err
+	}
+
+	var chunks []Chunk
+	for _, c := range all {
+		if u.Allows(c.DatasetID) {
+			chunks = append(chunks, c)
+		}
+	}
+	return chunks, nil
+}
+
+// Allows reports whether chunks of the dataset may be retrieved
+func (u *UserChunks) Allows(datasetID int) bool {
+	if len(u.DatasetIDs) == 0 {
+		return true
+	}
+	for _, id := range u.DatasetIDs {
+		if id == datasetID {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	retrieverMu sync.Mutex
+	retriever   Retriever
+)
+
+// SetRetriever overrides the retriever used by the package (e.g. in tests)
+func SetRetriever(r Retriever) {
+	retrieverMu.Lock()
+	defer retrieverMu.Unlock()
+	retriever = r
+}
+
+// chunkRetriever returns the configured retriever, building it from the environment on first use
+func chunkRetriever() (Retriever, error) {
+	retrieverMu.Lock()
+	defer retrieverMu.Unlock()
+	if retriever != nil {
+		return retriever, nil
+	}
+
+	r, err := NewRetriever()
+	if err != nil {
+		return nil, err
+	}
+	retriever = r
+	return retriever, nil
+}
+
+// NewRetriever builds a retriever from RETRIEVER ("hybrid", "vector", "bm25", "fulltext"
+// or "keyword"). Vector search runs in memory or, with VECTOR_STORE=pgvector, in Postgres,
+// where hybrid retrieval then also runs its lexical search.
+func NewRetriever() (Retriever, error) {
+	switch name := envOr("RETRIEVER", "hybrid"); name {
+	case "hybrid":
+		v, err := newVectorRetriever()
+		if err != nil {
+			// Lexical search still works without an embedding model
+			fmt.Println("No embedder configured, retrieving with BM25 only:", err)
+			return &BM25Retriever{}, nil
+		}
+		if _, ok := v.(*PgvectorRetriever); ok {
+			return &HybridRetriever{Retrievers: []Retriever{v, &FulltextRetriever{}}}, nil
+		}
+		return &HybridRetriever{Retrievers: []Retriever{v, &BM25Retriever{}}}, nil
+
+	case "vector":
+		return newVectorRetriever()
+
+	case "bm25":
+		return &BM25Retriever{}, nil
+
+	case "fulltext":
+		return &FulltextRetriever{}, nil
+
+	case "keyword":
+		return &KeywordRetriever{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown RETRIEVER: %s", name)
+	}
+}
+
+// newVectorRetriever builds the semantic retriever selected by VECTOR_STORE ("memory" or "pgvector")
+func newVectorRetriever() (Retriever, error) {
+	e, err := textEmbedder()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name := envOr("VECTOR_STORE", "memory"); name {
+	case "memory":
+		return &VectorRetriever{Embedder: e}, nil
+	case "pgvector":
+		return &PgvectorRetriever{Embedder: e}, nil
+	default:
+		return nil, fmt.Errorf("unknown VECTOR_STORE: %s", name)
+	}
+}
+
+// topK sorts the scored chunks, best first, and keeps the first k
+func topK(scored []ScoredChunk, k int) []ScoredChunk {
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	if k > 0 && len(scored) > k {
+		scored = scored[:k]
+	}
+	return scored
+}
+
+// =============== Hybrid ===============
+
+// rrfK dampens the weight of the top ranks in reciprocal rank fusion; 60 is the usual choice
+const rrfK = 60
+
+// HybridRetriever merges the rankings of several retrievers with reciprocal rank
+// fusion: a chunk scores the sum of 1/(60+rank) over the rankings it appears in,
+// so chunks ranked well by both semantic and lexical search come first
+type HybridRetriever struct {
+	Retrievers []Retriever
+}
+
+func (h *HybridRetriever) Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error) {
+	var fused []ScoredChunk
+	pos := map[string]int{}
+	for _, r := range h.Retrievers {
+		// Each ranking goes deeper than k, so chunks just outside one ranking still count
+		ranked, err := r.Retrieve(ctx, user, question, 2*max(k, 1))
+		if err != nil {
+			return nil, err
+		}
+		for rank, c := range ranked {
+			i, ok := pos[c.ChunkID]
+			if !ok {
+				i = len(fused)
+				pos[c.ChunkID] = i
+				fused = append(fused, ScoredChunk{Chunk: c.Chunk})
+			}
+			fused[i].Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+	return topK(fused, k), nil
+}
+
+// =============== Vector ===============
+
+// VectorRetriever ranks chunks by the cosine similarity of their embeddings to the question's
+type VectorRetriever struct {
+	Embedder Embedder
+}
+
+func (v *VectorRetriever) Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error) {
+	chunks, err := user.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := v.Embedder.EmbedQuery(ctx, question)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding question: %v", err)
+	}
+
+	// Chunks stored before embeddings existed, or embedded by another model, are
+	// embedded now and saved, so only the first question after a model switch pays for it
+	var stale []Chunk
+	var staleIdx []int
+	for i, c := range chunks {
+		if c.EmbeddingModel != v.Embedder.Name() || len(c.Embedding) == 0 {
+			stale = append(stale, c)
+			staleIdx = append(staleIdx, i)
+		}
+	}
+	vectors := make([][]float32, len(chunks))
+	for i, c := range chunks {
+		vectors[i] = c.Embedding
+	}
+	if len(stale) > 0 {
+		if err := v.reembed(ctx, user, stale); err != nil {
+			return nil, err
+		}
+		for i, idx := range staleIdx {
+			vectors[idx] = stale[i].Embedding
+		}
+	}
+
+	var scored []ScoredChunk
+	for i, c := range chunks {
+		if score := cosine(query, vectors[i]); score > 0 {
+			scored = append(scored, ScoredChunk{Chunk: c, Score: score})
+		}
+	}
+	return topK(scored, k), nil
+}
+
+// reembed embeds the chunks with the retriever's model and saves their new embeddings
+func (v *VectorRetriever) reembed(ctx context.Context, user *UserChunks, chunks []Chunk) error {
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Text
+	}
+	vectors, err := v.Embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("error embedding chunks: %v", err)
+	}
+	if len(vectors) != len(chunks) {
+		return fmt.Errorf("embedder returned %d vectors for %d chunks", len(vectors), len(chunks))
+	}
+	for i := range chunks {
+		chunks[i].Embedding, chunks[i].EmbeddingModel = vectors[i], v.Embedder.Name()
+	}
+
+	fmt.Printf("Embedded %d chunks of user %d with %s\n", len(chunks), user.UserID, v.Embedder.Name())
+	if err := user.store.UpdateEmbeddings(ctx, user.UserID, chunks); err != nil {
+		// The vectors still answer this question; the next one tries saving them again
+		fmt.Println("Error saving embeddings:", err)
+	}
+	return nil
+}
+
+// =============== Keyword ===============
+
+// KeywordRetriever picks the chunks whose document title or file name appears in the
+// question, or contains it
+type KeywordRetriever struct{}
+
+func (r *KeywordRetriever) Retrieve(ctx context.Context, user *UserChunks, question string, k int) ([]ScoredChunk, error) {
+	chunks, err := user.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	questionLower := strings.ToLower(question)
+	var results []ScoredChunk
+
+	for _, c := range chunks {
+		for _, name := range chunkNames(c) {
+			nameLower := strings.ToLower(name)
+			if nameLower == "" {
+				continue
+			}
+
+			if strings.Contains(questionLower, nameLower) ||
+				strings.Contains(nameLower, questionLower) {
+				results = append(results, ScoredChunk{Chunk: c, Score: 1})
+				break
+			}
+		}
+	}
+	return topK(results, k), nil
+}
+
+// chunkNames returns the names a question can refer to a chunk by: its document
+// title and the name of the file it came from
+func chunkNames(c Chunk) []string {
+	names := []string{strings.TrimSuffix(c.Source, filepath.Ext(c.Source))}
+	if len(c.HeadingPath) > 0 {
+		names = append(names, c.HeadingPath[0])
+	}
+	for i, name := range names {
+		names[i] = strings.ReplaceAll(name, "_", " ")
+	}
+	return names
+}
@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenCounter counts the model tokens in a text, for fitting text into budgets
+type TokenCounter interface {
+	CountTokens(text string) int
+}
+
+// HeuristicTokenCounter approximates token counts without a tokenizer. Tokenizers of
+// the supported models average about four characters per token for English prose.
+type HeuristicTokenCounter struct{}
+
+func (HeuristicTokenCounter) CountTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+var (
+	counterMu sync.Mutex
+	counter   TokenCounter = HeuristicTokenCounter{}
+)
+
+// SetTokenCounter overrides the counter used for chunking and context budgets
+func SetTokenCounter(c TokenCounter) {
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	counter = c
+}
+
+// estimateTokens counts the tokens in text with the configured counter
+func estimateTokens(text string) int {
+	counterMu.Lock()
+	c := counter
+	counterMu.Unlock()
+	return c.CountTokens(text)
+}
+
+// truncateTokens cuts text at a word boundary so it fits in budget tokens, keeping
+// its line breaks
+func truncateTokens(text string, budget int) string {
+	if estimateTokens(text) <= budget {
+		return text
+	}
+
+	// Offsets where a word ends, binary searched for the longest prefix that fits
+	var ends []int
+	inWord := false
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if inWord {
+				ends = append(ends, i)
+			}
+			inWord = false
+		} else {
+			inWord = true
+		}
+	}
+	lo, hi := 0, len(ends)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if estimateTokens(text[:ends[mid-1]]+" …") <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo == 0 {
+		return ""
+	}
+	return text[:ends[lo-1]] + " …"
+}
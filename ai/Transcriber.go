@@ -0,0 +1,195 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	speech "cloud.google.com/go/speech/apiv1"
+	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+)
+
+// TranscriptSegment is a piece of transcribed speech with its position in seconds
+type TranscriptSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcriber turns a 16kHz mono LINEAR16 WAV file into timed segments.
+// Segment times are relative to the start of the file.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error)
+}
+
+var (
+	transcriberMu sync.Mutex
+	transcriber   Transcriber
+)
+
+// SetTranscriber overrides the transcriber used by the package (e.g. in tests)
+func SetTranscriber(t Transcriber) {
+	transcriberMu.Lock()
+	defer transcriberMu.Unlock()
+	transcriber = t
+}
+
+// audioTranscriber returns the configured transcriber, building it from the environment on first use
+func audioTranscriber() (Transcriber, error) {
+	transcriberMu.Lock()
+	defer transcriberMu.Unlock()
+	if transcriber != nil {
+		return transcriber, nil
+	}
+
+	t, err := NewTranscriber()
+	if err != nil {
+		return nil, err
+	}
+	transcriber = t
+	return transcriber, nil
+}
+
+// NewTranscriber builds a transcriber from TRANSCRIBER ("google" or "whisper")
+func NewTranscriber() (Transcriber, error) {
+	switch name := envOr("TRANSCRIBER", "google"); name {
+	case "google":
+		return &GoogleTranscriber{
+			LanguageCode: envOr("SPEECH_LANGUAGE", "en-US"),
+		}, nil
+	case "whisper":
+		model := os.Getenv("WHISPER_MODEL")
+		if model == "" {
+			return nil, fmt.Errorf("WHISPER_MODEL not set")
+		}
+		return &WhisperTranscriber{
+			Bin:      envOr("WHISPER_BIN", "whisper-cli"),
+			Model:    model,
+			Language: envOr("WHISPER_LANGUAGE", "en"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown TRANSCRIBER: %s", name)
+	}
+}
+
+// =============== Google Speech-to-Text ===============
+
+// GoogleTranscriber uses the Google Speech-to-Text API
+type GoogleTranscriber struct {
+	LanguageCode string
+}
+
+func (g *GoogleTranscriber) Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
+	client, err := speech.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// Read the audio file
+	audioData, err := os.ReadFile(audioPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare the request
+	req := &speechpb.RecognizeRequest{
+		Config: &speechpb.RecognitionConfig{
+			Encoding:                   speechpb.RecognitionConfig_LINEAR16, // Assuming audio is in LINEAR16 format
+			LanguageCode:               g.LanguageCode,
+			EnableWordTimeOffsets:      true,
+			EnableAutomaticPunctuation: true,
+		},
+		Audio: &speechpb.RecognitionAudio{
+			AudioSource: &speechpb.RecognitionAudio_Content{Content: audioData},
+		},
+	}
+
+	// Call Speech-to-Text API
+	resp, err := client.Recognize(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect one segment per word so overlapping chunks can be stitched precisely
+	var segments []TranscriptSegment
+	prevEnd := 0.0
+	for _, result := range resp.Results {
+		if len(result.Alternatives) == 0 {
+			continue
+		}
+		alt := result.Alternatives[0]
+		if len(alt.Words) == 0 {
+			end := result.ResultEndTime.AsDuration().Seconds()
+			segments = append(segments, TranscriptSegment{Start: prevEnd, End: end, Text: alt.Transcript})
+			prevEnd = end
+			continue
+		}
+		for _, w := range alt.Words {
+			segments = append(segments, TranscriptSegment{
+				Start: w.StartTime.AsDuration().Seconds(),
+				End:   w.EndTime.AsDuration().Seconds(),
+				Text:  w.Word,
+			})
+		}
+		prevEnd = result.ResultEndTime.AsDuration().Seconds()
+	}
+	return segments, nil
+}
+
+// =============== whisper.cpp ===============
+
+// WhisperTranscriber runs a local whisper.cpp binary, so audio never leaves the server
+type WhisperTranscriber struct {
+	Bin      string
+	Model    string
+	Language string
+}
+
+// whisperLine matches output lines like "[00:01:02.500 --> 00:01:05.000]  text"
+var whisperLine = regexp.MustCompile(`^\[(\d+):(\d+):(\d+(?:\.\d+)?) --> (\d+):(\d+):(\d+(?:\.\d+)?)\]\s*(.*)$`)
+
+func (w *WhisperTranscriber) Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
+	var stdout, stderr bytes.Buffer
+	// -np: print nothing but the timestamped transcript
+	cmd := exec.CommandContext(ctx, w.Bin, "-m", w.Model, "-f", audioPath, "-l", w.Language, "-np")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("whisper failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseWhisperOutput(stdout.String()), nil
+}
+
+// parseWhisperOutput reads the timestamped segments printed by whisper.cpp
+func parseWhisperOutput(out string) []TranscriptSegment {
+	var segments []TranscriptSegment
+	for _, line := range strings.Split(out, "\n") {
+		m := whisperLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || strings.TrimSpace(m[7]) == "" {
+			continue
+		}
+		segments = append(segments, TranscriptSegment{
+			Start: clockSeconds(m[1], m[2], m[3]),
+			End:   clockSeconds(m[4], m[5], m[6]),
+			Text:  strings.TrimSpace(m[7]),
+		})
+	}
+	return segments
+}
+
+// clockSeconds converts hours, minutes and (fractional) seconds into seconds
+func clockSeconds(h, m, s string) float64 {
+	hours, _ := strconv.Atoi(h)
+	minutes, _ := strconv.Atoi(m)
+	seconds, _ := strconv.ParseFloat(s, 64)
+	return float64(hours*3600+minutes*60) + seconds
+}
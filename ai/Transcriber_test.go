@@ -0,0 +1,43 @@
+package ai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWhisperOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []TranscriptSegment
+	}{
+		{
+			name: "segments",
+			out: "\n[00:00:00.000 --> 00:00:04.320]   Welcome to the lecture.\n" +
+				"[00:00:04.320 --> 00:00:09.000]  Today: graphs.\r\n",
+			want: []TranscriptSegment{
+				{Start: 0, End: 4.32, Text: "Welcome to the lecture."},
+				{Start: 4.32, End: 9, Text: "Today: graphs."},
+			},
+		},
+		{
+			name: "hours",
+			out:  "[01:02:03.500 --> 01:02:05.250]  Any questions?",
+			want: []TranscriptSegment{{Start: 3723.5, End: 3725.25, Text: "Any questions?"}},
+		},
+		{
+			name: "blank segments and log lines",
+			out: "whisper_init_from_file: loading model\n" +
+				"[00:00:00.000 --> 00:00:02.000]   \n" +
+				"[00:00:02.000 --> 00:00:03.000]  [MUSIC]\n",
+			want: []TranscriptSegment{{Start: 2, End: 3, Text: "[MUSIC]"}},
+		},
+		{name: "empty", out: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseWhisperOutput(tt.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseWhisperOutput = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
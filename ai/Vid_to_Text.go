@@ -2,130 +2,254 @@ package ai
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
+	"math"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 
-	speech "cloud.google.com/go/speech/apiv1"
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
-	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+	"golang.org/x/sync/errgroup"
 )
 
-func VidToText(videoFile string, audioFile string) (string, error) {
+// VidToText transcribes a lecture recording (any audio or video file ffmpeg can
+// decode) into timed sections of cleaned text. For videos, the text of the slides
+// shown on screen is attached to the section they appeared in. info is the file's
+// ProbeMedia result.
+func VidToText(ctx context.Context, videoFile string, info *MediaInfo) ([]PageData, error) {
+	ws, cleanup, err := ingestWorkspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 
-	// Step 1: Extract audio from video
+	// Step 1: Extract the audio track as 16kHz mono WAV
 	fmt.Println("Extracting audio...")
-	err := extractAudio(videoFile, audioFile)
+	Emit(ctx, Event{Stage: StageExtractingAudio})
+	audioFile := ws.Path("audio.wav")
+	err = extractAudio(ctx, videoFile, audioFile)
 	if err != nil {
-		log.Fatalf("Audio extraction failed: %v", err)
+		return nil, fmt.Errorf("audio extraction failed: %v", err)
 	}
 
-	// Step 2: Transcribe audio to text
+	// Step 2: Transcribe audio to text in overlapping chunks
 	fmt.Println("Transcribing audio...")
-	transcribedText, err := transcribeAudio(audioFile)
+	segments, err := transcribeLongAudio(ctx, ws, audioFile)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Step 3: Send transcribed text to Gemini API for summarization
-	fmt.Println("Sending text to Gemini API for summarization...")
-	summarizedText, err := vidSendToGemini(transcribedText)
-	if err != nil {
-		return "", err
+	// Step 3: Read the slides the speaker shows but may never read aloud
+	var slides []SlideText
+	if envOr("KEYFRAMES", "on") != "off" && info.HasVideo {
+		fmt.Println("Extracting slides from keyframes...")
+		slides, err = slidesFromVideo(ctx, ws, videoFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Output the transcribed text and summarized text
-	// fmt.Println("\n--- Transcribed Text ---")
+	// Step 4: Send each section of the transcript to the LLM for cleanup
+	fmt.Println("Sending text to the LLM for cleanup...")
+	var sections []PageData
+	groups := groupSegments(segments, envFloat("TRANSCRIPT_SECTION_SECONDS", 300))
+	for i, group := range groups {
+		Emit(ctx, Event{Stage: StageCleanup, Page: i + 1, Total: len(groups)})
+		cleaned, err := vidSendToLLM(ctx, renderSegments(group))
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, PageData{
+			Page:  i + 1,
+			Text:  cleaned,
+			Start: group[0].Start,
+			End:   group[len(group)-1].End,
+		})
+	}
 
-	return summarizedText, nil
+	return attachSlides(sections, slides), nil
 }
 
-// Extracts audio from the video using ffmpeg and converts it to mono
-func extractAudio(videoPath, audioPath string) error {
-	cmd := exec.Command("ffmpeg", "-i", videoPath, "-vn", "-ac", "1", "-ar", "16000", "-acodec", "pcm_s16le", audioPath, "-y")
-	return cmd.Run()
+// MediaInfo describes an audio or video file as reported by ffprobe
+type MediaInfo struct {
+	Format   string  `json:"format"`
+	Duration float64 `json:"duration_seconds"`
+	HasVideo bool    `json:"has_video"`
+	HasAudio bool    `json:"has_audio"`
 }
 
-// Transcribes audio to text using Google Speech-to-Text API
-func transcribeAudio(audioPath string) (string, error) {
-	ctx := context.Background()
-	client, err := speech.NewClient(ctx)
+// ProbeMedia reads the container format, duration and stream types of a media file using ffprobe
+func ProbeMedia(ctx context.Context, file string) (*MediaInfo, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error",
+		"-show_entries", "format=format_name,duration:stream=codec_type:stream_disposition=attached_pic",
+		"-of", "json", file).Output()
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
 	}
-	defer client.Close()
 
-	// Read the audio file
-	audioData, err := ioutil.ReadFile(audioPath)
-	if err != nil {
-		return "", err
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe output: %v", err)
 	}
 
-	// Prepare the request
-	req := &speechpb.RecognizeRequest{
-		Config: &speechpb.RecognitionConfig{
-			Encoding:     speechpb.RecognitionConfig_LINEAR16, // Assuming audio is in LINEAR16 format
-			LanguageCode: "en-US",
-		},
-		Audio: &speechpb.RecognitionAudio{
-			AudioSource: &speechpb.RecognitionAudio_Content{Content: audioData},
-		},
+	info := &MediaInfo{Format: probe.Format.FormatName}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	for _, st := range probe.Streams {
+		switch st.CodecType {
+		case "video":
+			// Cover art embedded in audio files shows up as a one-frame video stream
+			if st.Disposition.AttachedPic == 0 {
+				info.HasVideo = true
+			}
+		case "audio":
+			info.HasAudio = true
+		}
 	}
+	return info, nil
+}
+
+// Extracts the audio track from an audio or video file using ffmpeg and converts it to mono
+func extractAudio(ctx context.Context, videoPath, audioPath string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", videoPath, "-vn", "-ac", "1", "-ar", "16000", "-acodec", "pcm_s16le", audioPath, "-y")
+	return cmd.Run()
+}
 
-	// Call Speech-to-Text API
-	resp, err := client.Recognize(ctx, req)
+// transcribeLongAudio splits the audio into overlapping chunks, transcribes them
+// concurrently and stitches the segments back into a single timeline
+func transcribeLongAudio(ctx context.Context, ws *Workspace, audioPath string) ([]TranscriptSegment, error) {
+	t, err := audioTranscriber()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Collect transcribed text
-	var transcript string
-	for _, result := range resp.Results {
-		for _, alt := range result.Alternatives {
-			transcript += alt.Transcript + " "
-		}
+	chunkDir, err := ws.Mkdir("audio-chunks")
+	if err != nil {
+		return nil, err
 	}
-	return transcript, nil
-}
-
-// Sends transcribed text to Gemini API for summarizationṇ
-func vidSendToGemini(text string) (string, error) {
-	ctx := context.Background()
-	apiKey := os.Getenv("GEMINI_API_KEY")
 
-	// Create a new Gemini client using the API key
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	overlap := envFloat("TRANSCRIBE_OVERLAP_SECONDS", 5)
+	chunks, err := splitWAV(audioPath, chunkDir, envFloat("TRANSCRIBE_CHUNK_SECONDS", 50), overlap)
 	if err != nil {
-		return "", fmt.Errorf("error creating client: %v", err)
+		return nil, err
+	}
+	fmt.Printf("Transcribing %d audio chunks...\n", len(chunks))
+
+	var mu sync.Mutex
+	done := 0
+	reportProgress(ctx, 0, len(chunks))
+
+	results := make([][]TranscriptSegment, len(chunks))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(envInt("TRANSCRIBE_WORKERS", 4), 1))
+	for i, chunk := range chunks {
+		g.Go(func() error {
+			segments, err := t.Transcribe(gctx, chunk.Path)
+			if err != nil {
+				return fmt.Errorf("transcribing %.0fs-%.0fs: %v", chunk.Start, chunk.End, err)
+			}
+			results[i] = segments
+
+			mu.Lock()
+			done++
+			reportProgress(ctx, done, len(chunks))
+			Emit(ctx, Event{Stage: StageTranscribing, Page: done, Total: len(chunks)})
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	defer client.Close()
 
-	// Specify the model
-	model := client.GenerativeModel("gemini-2.0-flash-001")
+	return stitchSegments(chunks, results, overlap), nil
+}
 
-	// Create the prompt for summarization
-	prompt := "Analyze the text and return more logical version of the text also clean it a bit. Add double star for heading, single star for subheading, etc beautify the output a bit. Do not summarize it. And also do not show anything else other than the text. If the formatted text is perfect then just return the text\n" + text
+// stitchSegments shifts each chunk's segments onto the recording's timeline and drops
+// the duplicates from the overlaps: a chunk hands over to the next one in the middle of
+// their overlap, and a segment is only kept if its midpoint falls after everything
+// already kept, so speech cut at a chunk edge is taken from whichever chunk heard it whole
+func stitchSegments(chunks []audioChunk, results [][]TranscriptSegment, overlap float64) []TranscriptSegment {
+	var stitched []TranscriptSegment
+	lastEnd := math.Inf(-1)
+	for i, chunk := range chunks {
+		handover := chunk.End - overlap/2
+		if i == len(chunks)-1 {
+			handover = math.Inf(1)
+		}
 
-	// Generate the content
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		return "", fmt.Errorf("error generating content: %v", err)
+		for _, seg := range results[i] {
+			seg.Start += chunk.Start
+			seg.End += chunk.Start
+			if mid := (seg.Start + seg.End) / 2; mid > lastEnd && mid < handover {
+				stitched = append(stitched, seg)
+				lastEnd = seg.End
+			}
+		}
 	}
+	return stitched
+}
 
-	// Check if the response contains candidates and extract the summarized text
-	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		summary, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
-		if ok {
-			// Return the summarized text
-			return string(summary), nil
-		} else {
-			return "", fmt.Errorf("unexpected response format: could not extract text")
+// groupSegments splits the timeline into sections of roughly sectionSec seconds
+func groupSegments(segments []TranscriptSegment, sectionSec float64) [][]TranscriptSegment {
+	var groups [][]TranscriptSegment
+	var current []TranscriptSegment
+	for _, seg := range segments {
+		if len(current) > 0 && seg.End-current[0].Start > sectionSec {
+			groups = append(groups, current)
+			current = nil
 		}
+		current = append(current, seg)
 	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+// renderSegments joins the segments into text with a [mm:ss] marker every 30 seconds
+func renderSegments(segments []TranscriptSegment) string {
+	var sb strings.Builder
+	lastMarker := math.Inf(-1)
+	for _, seg := range segments {
+		if seg.Start-lastMarker >= 30 {
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "[%s] ", formatTimestamp(seg.Start))
+			lastMarker = seg.Start
+		}
+		sb.WriteString(seg.Text)
+		sb.WriteString(" ")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// formatTimestamp renders seconds as m:ss, or h:mm:ss for recordings over an hour
+func formatTimestamp(sec float64) string {
+	total := int(sec)
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// Sends transcribed text to the LLM for cleanup
+func vidSendToLLM(ctx context.Context, text string) (string, error) {
+	// Create the prompt for cleanup
+	prompt := "Analyze the text and return more logical version of the text also clean it a bit. Add double star for heading, single star for subheading, etc beautify the output a bit. Keep every [m:ss] timestamp marker at the start of the sentence it belongs to. Do not summarize it. And also do not show anything else other than the text. If the formatted text is perfect then just return the text\n" + text
 
-	// Return an error if no response content is found
-	return "", fmt.Errorf("no response content found")
+	return generate(ctx, prompt)
 }
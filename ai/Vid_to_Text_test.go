@@ -0,0 +1,47 @@
+package ai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStitchSegments(t *testing.T) {
+	chunks := []audioChunk{{Start: 0, End: 50}, {Start: 45, End: 95}, {Start: 90, End: 120}}
+	results := [][]TranscriptSegment{
+		{
+			{Start: 0, End: 10, Text: "a"},
+			{Start: 40, End: 46, Text: "b"},
+			{Start: 46, End: 50, Text: "c cut"}, // past the handover at 47.5, heard whole by the next chunk
+		},
+		{
+			{Start: 0, End: 1, Text: "b tail"}, // already kept from the first chunk
+			{Start: 1, End: 6, Text: "c"},
+			{Start: 40, End: 45, Text: "d"},
+			{Start: 46, End: 50, Text: "e cut"},
+		},
+		{
+			{Start: 1, End: 5, Text: "e"},
+			{Start: 10, End: 20, Text: "f"},
+		},
+	}
+
+	want := []TranscriptSegment{
+		{Start: 0, End: 10, Text: "a"},
+		{Start: 40, End: 46, Text: "b"},
+		{Start: 46, End: 51, Text: "c"},
+		{Start: 85, End: 90, Text: "d"},
+		{Start: 91, End: 95, Text: "e"},
+		{Start: 100, End: 110, Text: "f"},
+	}
+	if got := stitchSegments(chunks, results, 5); !reflect.DeepEqual(got, want) {
+		t.Errorf("stitchSegments:\n%+v\nwant:\n%+v", got, want)
+	}
+}
+
+func TestStitchSegmentsSingleChunk(t *testing.T) {
+	segments := []TranscriptSegment{{Start: 0, End: 3, Text: "short"}, {Start: 3, End: 8, Text: "clip"}}
+	got := stitchSegments([]audioChunk{{Start: 0, End: 8}}, [][]TranscriptSegment{segments}, 5)
+	if !reflect.DeepEqual(got, segments) {
+		t.Errorf("stitchSegments = %+v, want %+v", got, segments)
+	}
+}
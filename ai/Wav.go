@@ -0,0 +1,177 @@
+package ai
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// wavInfo describes the PCM payload of a WAV file
+type wavInfo struct {
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	DataOffset    int64
+	DataSize      int64
+}
+
+// bytesPerSecond is the size of one second of audio
+func (w *wavInfo) bytesPerSecond() int64 {
+	return int64(w.SampleRate * w.Channels * w.BitsPerSample / 8)
+}
+
+// duration returns the length of the audio in seconds
+func (w *wavInfo) duration() float64 {
+	return float64(w.DataSize) / float64(w.bytesPerSecond())
+}
+
+// audioChunk is a slice of a longer recording, with its position in seconds
+type audioChunk struct {
+	Path  string
+	Start float64
+	End   float64
+}
+
+// readWAVInfo walks the RIFF chunks of a WAV file to find its format and data section
+func readWAVInfo(f *os.File) (*wavInfo, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &wavInfo{}
+	offset := int64(12)
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			return nil, errors.New("WAV file has no data chunk")
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			var format [16]byte
+			if _, err := io.ReadFull(f, format[:]); err != nil {
+				return nil, err
+			}
+			if tag := binary.LittleEndian.Uint16(format[0:2]); tag != 1 {
+				return nil, fmt.Errorf("WAV format %#x is not PCM", tag)
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(format[4:8]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(format[14:16]))
+			if info.Channels == 0 || info.BitsPerSample < 8 || info.SampleRate == 0 {
+				return nil, fmt.Errorf("invalid WAV format: %d channels of %d-bit samples at %d Hz", info.Channels, info.BitsPerSample, info.SampleRate)
+			}
+		case "data":
+			if info.SampleRate == 0 {
+				return nil, errors.New("WAV data chunk before fmt chunk")
+			}
+			info.DataOffset = offset
+			// Streamed WAVs may carry a placeholder size, so trust the file length instead
+			info.DataSize = min(size, stat.Size()-offset)
+			return info, nil
+		}
+
+		// Chunks are padded to an even size
+		offset += size + size%2
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// splitWAV cuts a WAV file into overlapping chunks of chunkSec seconds written to dir
+func splitWAV(path, dir string, chunkSec, overlapSec float64) ([]audioChunk, error) {
+	if chunkSec <= 0 || overlapSec < 0 {
+		return nil, fmt.Errorf("invalid chunk length (%vs) or overlap (%vs)", chunkSec, overlapSec)
+	}
+	if overlapSec >= chunkSec {
+		return nil, fmt.Errorf("chunk overlap (%vs) must be shorter than the chunk (%vs)", overlapSec, chunkSec)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := readWAVInfo(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep chunk boundaries aligned to whole sample frames
+	frame := int64(info.Channels * info.BitsPerSample / 8)
+	bps := info.bytesPerSecond()
+	chunkBytes := int64(chunkSec*float64(bps)) / frame * frame
+	stepBytes := int64((chunkSec-overlapSec)*float64(bps)) / frame * frame
+	if stepBytes < frame {
+		return nil, fmt.Errorf("chunks of %vs overlapping by %vs advance by less than one sample", chunkSec, overlapSec)
+	}
+
+	var chunks []audioChunk
+	for start := int64(0); start < info.DataSize; start += stepBytes {
+		size := min(chunkBytes, info.DataSize-start)
+
+		chunkPath := filepath.Join(dir, fmt.Sprintf("chunk-%04d.wav", len(chunks)))
+		if err := writeWAVChunk(f, info, start, size, chunkPath); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, audioChunk{
+			Path:  chunkPath,
+			Start: float64(start) / float64(bps),
+			End:   float64(start+size) / float64(bps),
+		})
+
+		if start+size >= info.DataSize {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// writeWAVChunk copies size bytes of PCM data starting at start into a new WAV file
+func writeWAVChunk(src *os.File, info *wavInfo, start, size int64, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	blockAlign := info.Channels * info.BitsPerSample / 8
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+size))
+	copy(header[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:24], uint16(info.Channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(info.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(info.SampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(info.BitsPerSample))
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(size))
+
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(src, info.DataOffset+start, size)); err != nil {
+		return err
+	}
+	return out.Close()
+}
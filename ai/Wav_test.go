@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestWAV writes seconds of 16-bit mono PCM at rate Hz, with a LIST chunk before
+// the data as ffmpeg writes it, and returns the PCM payload
+func writeTestWAV(t *testing.T, path string, rate int, seconds float64) []byte {
+	t.Helper()
+	pcm := make([]byte, int(seconds*float64(rate))*2)
+	for i := 0; i < len(pcm)/2; i++ {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(i))
+	}
+
+	var buf bytes.Buffer
+	put := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	list := []byte("INFOISFT\x05\x00\x00\x00test\x00") // odd size, padded
+	buf.WriteString("RIFF")
+	put(uint32(4 + 8 + 16 + 8 + len(list) + 1 + 8 + len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	put(uint32(16))
+	put([]uint16{1, 1})
+	put([]uint32{uint32(rate), uint32(rate * 2)})
+	put([]uint16{2, 16})
+	buf.WriteString("LIST")
+	put(uint32(len(list)))
+	buf.Write(list)
+	buf.WriteByte(0)
+	buf.WriteString("data")
+	put(uint32(len(pcm)))
+	buf.Write(pcm)
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return pcm
+}
+
+func TestSplitWAV(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "audio.wav")
+	pcm := writeTestWAV(t, src, 8000, 10.5)
+
+	chunks, err := splitWAV(src, dir, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ start, end float64 }{{0, 4}, {3, 7}, {6, 10}, {9, 10.5}}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v", len(chunks), len(want), chunks)
+	}
+	for i, c := range chunks {
+		if math.Abs(c.Start-want[i].start) > 1e-9 || math.Abs(c.End-want[i].end) > 1e-9 {
+			t.Errorf("chunk %d spans %v-%v, want %v-%v", i, c.Start, c.End, want[i].start, want[i].end)
+		}
+
+		f, err := os.Open(c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := readWAVInfo(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+		if info.SampleRate != 8000 || info.Channels != 1 || info.BitsPerSample != 16 {
+			t.Errorf("chunk %d format = %+v", i, info)
+		}
+		if math.Abs(info.duration()-(c.End-c.Start)) > 1e-9 {
+			t.Errorf("chunk %d lasts %vs, want %vs", i, info.duration(), c.End-c.Start)
+		}
+
+		data, _ := os.ReadFile(c.Path)
+		from := int(c.Start * 16000)
+		if !bytes.Equal(data[info.DataOffset:], pcm[from:from+int(info.DataSize)]) {
+			t.Errorf("chunk %d does not hold the audio from %vs", i, c.Start)
+		}
+	}
+}
+
+func TestSplitWAVRejectsOverlapOverChunk(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "audio.wav")
+	writeTestWAV(t, src, 8000, 1)
+
+	if _, err := splitWAV(src, dir, 5, 5); err == nil {
+		t.Error("splitWAV accepted an overlap as long as the chunk")
+	}
+}
+
+func TestSplitWAVRejectsInvalidChunking(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "audio.wav")
+	writeTestWAV(t, src, 16000, 1)
+
+	tests := []struct {
+		name              string
+		chunkSec, overlap float64
+	}{
+		{"zero chunk", 0, -1},
+		{"negative overlap", 5, -1},
+		{"step under one sample", 50, 49.99999},
+	}
+	for _, tt := range tests {
+		if chunks, err := splitWAV(src, dir, tt.chunkSec, tt.overlap); err == nil {
+			t.Errorf("%s: splitWAV accepted it and wrote %d chunks", tt.name, len(chunks))
+		}
+	}
+}
+
+func TestReadWAVInfoRejectsInvalidFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int // of the fmt field in the file
+		value  uint16
+	}{
+		{"not PCM", 20, 3},
+		{"no channels", 22, 0},
+		{"no bits per sample", 34, 0},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "audio.wav")
+		writeTestWAV(t, path, 8000, 1)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.LittleEndian.PutUint16(data[tt.offset:], tt.value)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = readWAVInfo(f)
+		f.Close()
+		if err == nil {
+			t.Errorf("%s: readWAVInfo accepted it", tt.name)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// Workspace is a scratch directory owned by a single ingestion. Everything the
+// pipeline renders or extracts (page images, audio, chunks) lives inside it, so
+// concurrent ingestions never share files and Close removes all of it.
+type Workspace struct {
+	Dir string
+}
+
+// NewWorkspace creates a fresh workspace under WORKSPACE_ROOT (default: the system temp dir)
+func NewWorkspace() (*Workspace, error) {
+	root := envOr("WORKSPACE_ROOT", filepath.Join(os.TempDir(), "edubank"))
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(root, "ingest-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{Dir: dir}, nil
+}
+
+// Path returns the path of a file inside the workspace
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.Dir, name)
+}
+
+// Mkdir creates a directory inside the workspace and returns its path
+func (w *Workspace) Mkdir(name string) (string, error) {
+	dir := w.Path(name)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// Close removes the workspace and everything in it
+func (w *Workspace) Close() error {
+	return os.RemoveAll(w.Dir)
+}
+
+type workspaceKey struct{}
+
+// WithWorkspace returns a context whose ingestion steps keep their scratch files in ws
+func WithWorkspace(ctx context.Context, ws *Workspace) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, ws)
+}
+
+// ingestWorkspace returns the workspace attached to ctx, or a new one that is
+// removed when the returned cleanup func is called
+func ingestWorkspace(ctx context.Context) (*Workspace, func(), error) {
+	if ws, ok := ctx.Value(workspaceKey{}).(*Workspace); ok {
+		return ws, func() {}, nil
+	}
+
+	ws, err := NewWorkspace()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ws, func() { ws.Close() }, nil
+}
@@ -1,10 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"fmt"
-	"os"
 
 	"github.com/edubank/ai"
 	"github.com/edubank/db"
@@ -25,23 +24,19 @@ func AIHandler(c *gin.Context) {
 		return
 	}
 
-	// Construct dataset path
-	datasetPath := fmt.Sprintf("ai/users/%d/dataset.jsonl", userID)
-
-	// Check if file exists
-	if _, err := os.Stat(datasetPath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
-		return
-	}
-
 	// Bind incoming JSON request
 	var request struct {
-		Question string `json:"question"`
-		Mode     string `json:"mode"` // "qa", "exam", "transform"
+		Question string          `json:"question"`
+		Mode     string          `json:"mode"` // "qa", "exam", "transform"
+		Exam     *ai.ExamRequest `json:"exam"` // required in exam mode
 	}
 
-	if err := c.BindJSON(&request); err != nil {
+	if err := c.ShouldBindJSON(&request); err != nil {
 		log.Printf("Invalid AI request: %v", err)
+		if fields := fieldErrors(err, &request); fields != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "fields": fields})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
@@ -50,14 +45,45 @@ func AIHandler(c *gin.Context) {
 		request.Mode = "qa" // default to normal QA
 	}
 
+	if request.Mode == "exam" {
+		examHandler(c, email, userID, request.Exam)
+		return
+	}
+
 	// Call AI function
-	answer, err := ai.AI(request.Mode, request.Question, datasetPath)
+	answer, err := ai.AI(ctx, request.Mode, request.Question, userID)
+	if errors.Is(err, ai.ErrDatasetNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return
+	}
 	if err != nil {
 		log.Printf("Error processing AI request: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	log.Printf("User: %s | Mode: %s | Question: %s | Answer: %s", email, request.Mode, request.Question, answer)
-	c.JSON(http.StatusOK, gin.H{"answer": answer})
+	log.Printf("User: %s | Mode: %s | Question: %s | Answer: %s | Citations: %d", email, request.Mode, request.Question, answer.Text, len(answer.Citations))
+	c.JSON(http.StatusOK, gin.H{"answer": answer.Text, "citations": answer.Citations})
+}
+
+// examHandler generates the exam described by the request's exam parameters
+func examHandler(c *gin.Context, email string, userID int, req *ai.ExamRequest) {
+	if req == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exam parameters are required in exam mode"})
+		return
+	}
+
+	questions, err := ai.GenerateExam(c.Request.Context(), userID, req)
+	if errors.Is(err, ai.ErrDatasetNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating exam: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("User: %s | Mode: exam | Topics: %v | Questions: %d", email, req.Topics, len(questions))
+	c.JSON(http.StatusOK, gin.H{"questions": questions})
 }
@@ -2,15 +2,21 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 	"log"
 
+	"github.com/edubank/ai"
 	"github.com/edubank/db"
+	"github.com/edubank/jobs"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 )
 
 // UploadDatasetHandler
@@ -32,6 +38,25 @@ func UploadDatasetHandler(c *gin.Context) {
 		return
 	}
 
+	// Check the file's content against its extension before accepting it
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+		return
+	}
+	ft, err := detectFileType(src, file.Filename)
+	src.Close()
+	if err != nil {
+		var unsupported *UnsupportedFileTypeError
+		var mismatch *FileTypeMismatchError
+		if errors.As(err, &unsupported) || errors.As(err, &mismatch) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+		return
+	}
+
 	// Create user-specific directory
 	userDir := fmt.Sprintf("ai/users/%d", userID)
 
@@ -42,23 +67,31 @@ func UploadDatasetHandler(c *gin.Context) {
 		return
 	}
 
-	savePath := filepath.Join(assetsDir, file.Filename)
+	// Each upload gets its own directory, so re-uploading a file never rewrites the copy
+	// an earlier job of the dataset may still be reading
+	uploadDir, err := os.MkdirTemp(assetsDir, "upload-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload dir"})
+		return
+	}
+	savePath := filepath.Join(uploadDir, filepath.Base(file.Filename))
 	if err := c.SaveUploadedFile(file, savePath); err != nil {
+		os.RemoveAll(uploadDir)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "file save failed"})
 		return
 	}
 
 	// Save metadata in database
-	var existingID int
+	var datasetID int
 	err = db.Pool.QueryRow(ctx,
 	    "SELECT id FROM datasets WHERE filename=$1 AND user_id=$2",
 	    file.Filename, userID,
-	).Scan(&existingID)
+	).Scan(&datasetID)
 
 	if err == nil {
         // Replace existing record
         _, err := db.Pool.Exec(ctx,
-			"UPDATE datasets SET file_url=$1, uploaded_at=$2 WHERE filename=$3 AND user_id=$4", savePath, time.Now(), file.Filename, userID,
+			"UPDATE datasets SET file_url=$1, size_bytes=$2, mime_type=$3, uploaded_at=$4 WHERE id=$5", savePath, file.Size, ft.MIME, time.Now(), datasetID,
 		)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "db update failed"})
@@ -66,23 +99,30 @@ func UploadDatasetHandler(c *gin.Context) {
         }
     } else {
         // Insert new record
-        _, err := db.Pool.Exec(ctx,
-			"INSERT INTO datasets (user_id, filename, file_url, size_bytes, uploaded_at) VALUES ($1,$2,$3,$4,$5)",
-			userID, file.Filename, savePath, file.Size, time.Now(),
-		)
+        err := db.Pool.QueryRow(ctx,
+			"INSERT INTO datasets (user_id, filename, file_url, size_bytes, mime_type, uploaded_at) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id",
+			userID, file.Filename, savePath, file.Size, ft.MIME, time.Now(),
+		).Scan(&datasetID)
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "db insert failed"})
 			return
         }
     }
 
-	log.Printf("Dataset path: %s/dataset.jsonl", userDir)
-	if err := FileUploadHandler(savePath, fmt.Sprintf("%s/dataset.jsonl", userDir)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "file processing failed"})
+	// Processing can take minutes, so hand it to the ingestion workers
+	jobID, err := jobs.Enqueue(ctx, userID, datasetID, savePath)
+	if err != nil {
+		log.Printf("enqueue job error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue processing"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "dataset uploaded", "filename": file.Filename})
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "dataset queued for processing",
+		"filename":   file.Filename,
+		"dataset_id": datasetID,
+		"job_id":     jobID,
+	})
 }
 
 
@@ -98,7 +138,7 @@ func ListDatasetsHandler(c *gin.Context) {
 	}
 
 	rows, err := db.Pool.Query(ctx,
-		"SELECT id, filename, file_url, size_bytes, uploaded_at FROM datasets WHERE user_id=$1 ORDER BY uploaded_at DESC", userID)
+		"SELECT id, filename, file_url, size_bytes, mime_type, metadata, uploaded_at FROM datasets WHERE user_id=$1 ORDER BY uploaded_at DESC", userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db query failed"})
 		return
@@ -108,19 +148,70 @@ func ListDatasetsHandler(c *gin.Context) {
 	var datasets []map[string]interface{}
 	for rows.Next() {
 		var id int
-		var filename, fileURL string
+		var filename, fileURL, mimeType string
 		var size int64
+		var metadata map[string]interface{}
 		var uploadedAt time.Time
-		rows.Scan(&id, &filename, &fileURL, &size, &uploadedAt)
+		rows.Scan(&id, &filename, &fileURL, &size, &mimeType, &metadata, &uploadedAt)
 
 		datasets = append(datasets, map[string]interface{}{
 			"id":         id,
 			"filename":   filename,
 			"file_url":   fileURL,
 			"size_bytes": size,
+			"mime_type":  mimeType,
+			"metadata":   metadata,
 			"uploaded_at": uploadedAt,
 		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{"datasets": datasets})
-}
\ No newline at end of file
+}
+// DeleteDatasetHandler removes a dataset, its extracted chunks and the uploaded file
+func DeleteDatasetHandler(c *gin.Context) {
+	email := c.GetString("email")
+	ctx := context.Background()
+
+	var userID int
+	if err := db.Pool.QueryRow(ctx, "SELECT id FROM users WHERE email=$1", email).Scan(&userID); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset id"})
+		return
+	}
+
+	var fileURL string
+	err = db.Pool.QueryRow(ctx, "SELECT file_url FROM datasets WHERE id=$1 AND user_id=$2", id, userID).Scan(&fileURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db query failed"})
+		return
+	}
+
+	// Chunks in Postgres go with the datasets row, but other stores need clearing
+	if err := ai.DeleteDataset(ctx, userID, id); err != nil {
+		log.Printf("delete chunks error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete dataset content"})
+		return
+	}
+	if _, err := db.Pool.Exec(ctx, "DELETE FROM datasets WHERE id=$1 AND user_id=$2", id, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db delete failed"})
+		return
+	}
+	if err := os.Remove(fileURL); err != nil && !os.IsNotExist(err) {
+		log.Printf("delete upload error: %v", err)
+	}
+	// Uploads live in a directory of their own; older ones sit directly in Assets
+	if dir := filepath.Dir(fileURL); strings.HasPrefix(filepath.Base(dir), "upload-") {
+		os.Remove(dir)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "dataset deleted", "dataset_id": id})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edubank/ai"
+	"github.com/edubank/db"
+	"github.com/edubank/jobs"
+	"github.com/edubank/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// eventBroker fans ingestion events out to the SSE clients watching a dataset
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[int]map[chan ai.Event]struct{}
+	last map[int]ai.Event // latest event of each running ingestion, replayed to new subscribers
+}
+
+var ingestEvents = &eventBroker{
+	subs: map[int]map[chan ai.Event]struct{}{},
+	last: map[int]ai.Event{},
+}
+
+// publish sends ev to every subscriber of the dataset without blocking the pipeline
+func (b *eventBroker) publish(datasetID int, ev ai.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ev.Stage == ai.StageDone || ev.Stage == ai.StageFailed {
+		delete(b.last, datasetID)
+	} else {
+		b.last[datasetID] = ev
+	}
+
+	for ch := range b.subs[datasetID] {
+		select {
+		case ch <- ev:
+		default: // slow client, drop the event
+		}
+	}
+}
+
+// forget drops the last event of a dataset whose ingestion stopped on this instance
+// without an outcome
+func (b *eventBroker) forget(datasetID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.last, datasetID)
+}
+
+// subscribe registers a new subscriber and returns the last event of a running ingestion, if any
+func (b *eventBroker) subscribe(datasetID int) (chan ai.Event, *ai.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan ai.Event, 64)
+	if b.subs[datasetID] == nil {
+		b.subs[datasetID] = map[chan ai.Event]struct{}{}
+	}
+	b.subs[datasetID][ch] = struct{}{}
+
+	if ev, ok := b.last[datasetID]; ok {
+		return ch, &ev
+	}
+	return ch, nil
+}
+
+func (b *eventBroker) unsubscribe(datasetID int, ch chan ai.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs[datasetID], ch)
+	if len(b.subs[datasetID]) == 0 {
+		delete(b.subs, datasetID)
+	}
+}
+
+// eventsTokenTTL is how long a token for the event stream can be used to connect
+const eventsTokenTTL = time.Minute
+
+// ownedDataset returns the dataset of the :id parameter if it belongs to the user,
+// answering the request otherwise
+func ownedDataset(c *gin.Context, ctx context.Context) (int, bool) {
+	email := c.GetString("email")
+
+	var userID int
+	if err := db.Pool.QueryRow(ctx, "SELECT id FROM users WHERE email=$1", email).Scan(&userID); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return 0, false
+	}
+
+	datasetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset id"})
+		return 0, false
+	}
+
+	var ownerID int
+	if err := db.Pool.QueryRow(ctx, "SELECT user_id FROM datasets WHERE id=$1", datasetID).Scan(&ownerID); err != nil || ownerID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dataset not found"})
+		return 0, false
+	}
+	return datasetID, true
+}
+
+// DatasetEventsTokenHandler issues a short-lived token that opens the event stream of a
+// dataset, for clients such as EventSource that pass it as ?token= instead of a header
+func DatasetEventsTokenHandler(c *gin.Context) {
+	datasetID, ok := ownedDataset(c, context.Background())
+	if !ok {
+		return
+	}
+
+	expires := time.Now().Add(eventsTokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email":      c.GetString("email"),
+		"scope":      middleware.EventsScope,
+		"dataset_id": strconv.Itoa(datasetID),
+		"exp":        expires.Unix(),
+	})
+
+	tokenString, err := token.SignedString(jwtSecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token Error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      tokenString,
+		"expires_at": expires,
+	})
+}
+
+// DatasetEventsHandler streams the ingestion stages of a dataset as Server-Sent Events
+func DatasetEventsHandler(c *gin.Context) {
+	ctx := context.Background()
+
+	datasetID, ok := ownedDataset(c, ctx)
+	if !ok {
+		return
+	}
+
+	// Subscribe before reading the job so no transition is missed in between
+	ch, last := ingestEvents.subscribe(datasetID)
+	defer ingestEvents.unsubscribe(datasetID, ch)
+
+	job, err := jobs.Latest(ctx, datasetID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no ingestion for this dataset"})
+		return
+	}
+
+	// Tell the client where the ingestion currently is
+	var first ai.Event
+	switch {
+	case job.Status == jobs.StatusDone:
+		first = ai.Event{Stage: ai.StageDone, Time: time.Now()}
+	case job.Status == jobs.StatusFailed:
+		first = ai.Event{Stage: ai.StageFailed, Message: job.Error, Time: time.Now()}
+	case last != nil:
+		first = *last
+	case job.Status == jobs.StatusQueued:
+		first = ai.Event{Stage: ai.StageQueued, Time: time.Now()}
+	}
+	if first.Stage != "" {
+		c.SSEvent(first.Stage, first)
+		c.Writer.Flush()
+		if first.Stage == ai.StageDone || first.Stage == ai.StageFailed {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev := <-ch:
+			c.SSEvent(ev.Stage, ev)
+			return ev.Stage != ai.StageDone && ev.Stage != ai.StageFailed
+		case <-keepAlive.C:
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
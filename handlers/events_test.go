@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edubank/ai"
+	"github.com/edubank/jobs"
+)
+
+func TestJobFinishedClearsLastEvent(t *testing.T) {
+	for _, status := range []string{jobs.StatusQueued, jobs.StatusRunning, jobs.StatusDone, jobs.StatusFailed} {
+		const datasetID = 41
+		ingestEvents.publish(datasetID, ai.Event{Stage: ai.StageTranscribing, Time: time.Now()})
+
+		JobFinished(&jobs.Job{DatasetID: datasetID, Status: status})
+
+		ch, last := ingestEvents.subscribe(datasetID)
+		ingestEvents.unsubscribe(datasetID, ch)
+		if last != nil {
+			t.Errorf("job %s: new subscribers are sent stale stage %q", status, last.Stage)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// fileKind selects the ingestion pipeline for an uploaded file
+type fileKind string
+
+const (
+	kindPDF   fileKind = "pdf"
+	kindImage fileKind = "image"
+	kindMedia fileKind = "media" // any audio or video ffmpeg can decode
+	kindDOCX  fileKind = "docx"
+	kindPPTX  fileKind = "pptx"
+)
+
+// fileFormat is an accepted upload format and the extensions allowed for it
+type fileFormat struct {
+	MIME       string
+	Kind       fileKind
+	Extensions []string
+}
+
+// allowedFormats is the allow-list of upload formats, matched against the file's magic bytes
+var allowedFormats = []fileFormat{
+	{MIME: "application/pdf", Kind: kindPDF, Extensions: []string{".pdf"}},
+	{MIME: "image/png", Kind: kindImage, Extensions: []string{".png"}},
+	{MIME: "image/jpeg", Kind: kindImage, Extensions: []string{".jpg", ".jpeg"}},
+	{MIME: "video/mp4", Kind: kindMedia, Extensions: []string{".mp4"}},
+	{MIME: "video/x-m4v", Kind: kindMedia, Extensions: []string{".m4v"}},
+	{MIME: "video/quicktime", Kind: kindMedia, Extensions: []string{".mov"}},
+	{MIME: "video/x-matroska", Kind: kindMedia, Extensions: []string{".mkv"}},
+	{MIME: "video/webm", Kind: kindMedia, Extensions: []string{".webm", ".weba"}},
+	{MIME: "audio/x-m4a", Kind: kindMedia, Extensions: []string{".m4a"}},
+	{MIME: "audio/mp4", Kind: kindMedia, Extensions: []string{".m4a", ".mp4"}},
+	{MIME: "audio/mpeg", Kind: kindMedia, Extensions: []string{".mp3"}},
+	{MIME: "audio/wav", Kind: kindMedia, Extensions: []string{".wav"}},
+	{MIME: "audio/ogg", Kind: kindMedia, Extensions: []string{".ogg", ".oga"}},
+	{MIME: "audio/flac", Kind: kindMedia, Extensions: []string{".flac"}},
+	{MIME: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Kind: kindDOCX, Extensions: []string{".docx"}},
+	{MIME: "application/vnd.openxmlformats-officedocument.presentationml.presentation", Kind: kindPPTX, Extensions: []string{".pptx"}},
+}
+
+// fileType is the detected format of an upload
+type fileType struct {
+	MIME string
+	Kind fileKind
+}
+
+// detectFileType sniffs the content of r and checks it against the allow-list and
+// the extension of filename, so a renamed or disguised file is rejected
+func detectFileType(r io.Reader, filename string) (*fileType, error) {
+	detected, err := mimetype.DetectReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, f := range allowedFormats {
+		if !detected.Is(f.MIME) {
+			continue
+		}
+		for _, allowed := range f.Extensions {
+			if ext == allowed {
+				return &fileType{MIME: f.MIME, Kind: f.Kind}, nil
+			}
+		}
+		return nil, &FileTypeMismatchError{File: filename, Detected: f.MIME, Extension: ext}
+	}
+
+	return nil, &UnsupportedFileTypeError{File: filename, Detected: detected.String()}
+}
+
+// UnsupportedFileTypeError is returned when the uploaded file type is not supported
+type UnsupportedFileTypeError struct {
+	File     string
+	Detected string
+}
+
+func (e *UnsupportedFileTypeError) Error() string {
+	if e.Detected != "" {
+		return fmt.Sprintf("unsupported file type: %s (content is %s)", e.File, e.Detected)
+	}
+	return "unsupported file type: " + e.File
+}
+
+// FileTypeMismatchError is returned when the file's content does not match its extension
+type FileTypeMismatchError struct {
+	File      string
+	Detected  string
+	Extension string
+}
+
+func (e *FileTypeMismatchError) Error() string {
+	return fmt.Sprintf("file type mismatch: %s has extension %q but its content is %s", e.File, e.Extension, e.Detected)
+}
@@ -1,78 +1,175 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/edubank/ai"
+	"github.com/edubank/db"
+	"github.com/edubank/jobs"
 )
 
-// FileUploadHandler handles the /load POST request for file uploads
-func FileUploadHandler(dst string, jsonDir string) error {
-	// Process the file
-	if err := processFile(dst, jsonDir); err != nil {
-		log.Printf("Error processing file %s: %v", dst, err)
-		return err
+// ProcessJob runs a queued ingestion job, recording its progress on the job row
+// and streaming its stage transitions to the dataset's event subscribers
+func ProcessJob(ctx context.Context, job *jobs.Job) error {
+	ctx = ai.WithProgress(ctx, func(done, total int) {
+		if err := job.SetProgress(ctx, done, total); err != nil {
+			log.Printf("Job %d: failed to save progress: %v", job.ID, err)
+		}
+	})
+	ctx = ai.WithEvents(ctx, func(ev ai.Event) {
+		ingestEvents.publish(job.DatasetID, ev)
+	})
+
+	return FileUploadHandler(ctx, job.UserID, job.DatasetID, job.FilePath)
+}
+
+// JobFinished tells the dataset's event subscribers how its ingestion ended. It runs
+// after the job's status is saved, so clients connecting from then on read the outcome
+// from the job instead of waiting for an event that was already sent. A job handed back
+// or taken over has no outcome yet; its last stage is only forgotten, since it no
+// longer describes where the ingestion is.
+func JobFinished(job *jobs.Job) {
+	if job.Status != jobs.StatusDone && job.Status != jobs.StatusFailed {
+		ingestEvents.forget(job.DatasetID)
+		return
 	}
 
-	return nil
+	ev := ai.Event{Stage: ai.StageDone, Time: time.Now()}
+	if job.Status == jobs.StatusFailed {
+		ev = ai.Event{Stage: ai.StageFailed, Message: job.Error, Time: time.Now()}
+	}
+	ingestEvents.publish(job.DatasetID, ev)
 }
 
-// processFile determines file type and calls AI library functions
-func processFile(file string, jsonFile string) error {
-	if strings.HasSuffix(strings.ToLower(file), ".pdf") {
-		log.Println("Starting PDF to text conversion...")
-		extractedOutput, err := ai.PdfToText(file)
-		if err != nil {
-			return err
-		}
+// ReingestDatasets queues an ingestion job for each dataset the store has no chunks
+// for, such as uploads whose content was only kept in a legacy dataset file. Datasets
+// with a pending or failed latest job are left alone, so failures aren't retried on every start.
+func ReingestDatasets(ctx context.Context) error {
+	datasets, err := ai.DatasetsWithoutChunks(ctx)
+	if err != nil {
+		return err
+	}
 
-		log.Println("Sending text to JSON formatter...")
-		log.Println("Json File: ", jsonFile)
-		if err := ai.Format(extractedOutput, "", jsonFile); err != nil {
+	for _, d := range datasets {
+		job, err := jobs.Latest(ctx, d.ID)
+		if err != nil && !errors.Is(err, jobs.ErrNotFound) {
 			return err
 		}
+		if job != nil && job.Status != jobs.StatusDone {
+			continue
+		}
+		if _, err := os.Stat(d.FilePath); err != nil {
+			log.Printf("Dataset %d has no content and its upload is unavailable: %v", d.ID, err)
+			continue
+		}
 
-	} else if strings.HasSuffix(strings.ToLower(file), ".mp4") {
-		log.Println("Starting video to text conversion...")
-		audioFile := "ai/Assets/audio.wav"
-		extractedOutput, err := ai.VidToText(file, audioFile)
+		jobID, err := jobs.Enqueue(ctx, d.UserID, d.ID, d.FilePath)
 		if err != nil {
 			return err
 		}
+		log.Printf("Dataset %d has no content, queued job %d to ingest it again", d.ID, jobID)
+	}
+	return nil
+}
+
+// FileUploadHandler processes an uploaded file into the user's dataset
+func FileUploadHandler(ctx context.Context, userID, datasetID int, dst string) error {
+	// Process the file
+	if err := processFile(ctx, userID, datasetID, dst); err != nil {
+		log.Printf("Error processing file %s: %v", dst, err)
+		return err
+	}
+
+	return nil
+}
 
-		log.Println("Sending text to JSON formatter...")
-		if err := ai.Format(nil, extractedOutput, jsonFile); err != nil {
+// processFile detects the file type from its content and calls AI library functions
+func processFile(ctx context.Context, userID, datasetID int, file string) error {
+	ft, err := detectFileTypeOnDisk(file)
+	if err != nil {
+		return err
+	}
+
+	// Scratch files of this ingestion live in their own directory, removed however it ends
+	ws, err := ai.NewWorkspace()
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	ctx = ai.WithWorkspace(ctx, ws)
+
+	var pages []ai.PageData
+	imageText := ""
+
+	switch ft.Kind {
+	case kindPDF:
+		log.Println("Starting PDF to text conversion...")
+		pages, err = ai.PdfToText(ctx, file)
+
+	case kindMedia:
+		log.Println("Starting audio/video to text conversion...")
+		info, probeErr := ai.ProbeMedia(ctx, file)
+		if probeErr != nil {
+			return probeErr
+		}
+		if !info.HasAudio {
+			return fmt.Errorf("%s has no audio track to transcribe", filepath.Base(file))
+		}
+		if err = saveDatasetMetadata(ctx, datasetID, info); err != nil {
 			return err
 		}
 
-	} else if strings.HasSuffix(strings.ToLower(file), ".png") ||
-		strings.HasSuffix(strings.ToLower(file), ".jpeg") ||
-		strings.HasSuffix(strings.ToLower(file), ".jpg") {
+		pages, err = ai.VidToText(ctx, file, info)
 
+	case kindImage:
 		log.Println("Starting image to text conversion...")
-		extractedOutput, err := ai.ImgToText(nil, file)
-		if err != nil {
-			return err
-		}
+		imageText, err = ai.ImgToText(ctx, file)
 
-		log.Println("Sending text to JSON formatter...")
-		if err := ai.Format(nil, extractedOutput, jsonFile); err != nil {
-			return err
-		}
+	case kindDOCX:
+		log.Println("Starting DOCX to text conversion...")
+		pages, err = ai.DocxToText(ctx, file)
+
+	case kindPPTX:
+		log.Println("Starting PPTX to text conversion...")
+		pages, err = ai.PptxToText(ctx, file)
 
-	} else {
-		return &UnsupportedFileTypeError{File: file}
+	default:
+		return &UnsupportedFileTypeError{File: file, Detected: ft.MIME}
+	}
+	if err != nil {
+		return err
 	}
 
-	return nil
+	log.Println("Sending text to JSON formatter...")
+	ai.Emit(ctx, ai.Event{Stage: ai.StageFormatting})
+	src := ai.Source{UserID: userID, DatasetID: datasetID, Filename: filepath.Base(file)}
+	return ai.Format(ctx, src, pages, imageText)
 }
 
-// UnsupportedFileTypeError is returned when the uploaded file type is not supported
-type UnsupportedFileTypeError struct {
-	File string
+// saveDatasetMetadata merges v, encoded as a JSON object, into the dataset's metadata
+func saveDatasetMetadata(ctx context.Context, datasetID int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = db.Pool.Exec(ctx, "UPDATE datasets SET metadata = metadata || $1::jsonb WHERE id=$2", string(data), datasetID)
+	return err
 }
 
-func (e *UnsupportedFileTypeError) Error() string {
-	return "unsupported file type: " + e.File
+// detectFileTypeOnDisk sniffs the type of a saved upload
+func detectFileTypeOnDisk(file string) (*fileType, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return detectFileType(f, filepath.Base(file))
 }
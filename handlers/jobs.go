@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/edubank/db"
+	"github.com/edubank/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+// JobStatusHandler reports the status and progress of an ingestion job
+func JobStatusHandler(c *gin.Context) {
+	email := c.GetString("email")
+	ctx := context.Background()
+
+	var userID int
+	if err := db.Pool.QueryRow(ctx, "SELECT id FROM users WHERE email=$1", email).Scan(&userID); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+
+	job, err := jobs.Get(ctx, id)
+	if errors.Is(err, jobs.ErrNotFound) || (err == nil && job.UserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
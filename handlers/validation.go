@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes why one field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. exam.question_types[1]
+	Tag     string `json:"tag"`   // the failed rule, e.g. max
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func init() {
+	// Report fields by their JSON names, as the client sent them
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// fieldErrors turns the error of binding a request into req into the fields it rejected.
+// It returns nil for errors that aren't about a field, such as malformed JSON.
+func fieldErrors(err error, req interface{}) []FieldError {
+	var verrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &verrs):
+		out := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			out = append(out, FieldError{
+				Field:   fieldPath(fe.Namespace(), req),
+				Tag:     fe.Tag(),
+				Param:   fe.Param(),
+				Message: fieldMessage(fe),
+			})
+		}
+		return out
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Tag:     "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("must be a %s, not a %s", jsonType(typeErr.Type), typeErr.Value),
+		}}
+	}
+	return nil
+}
+
+// fieldPath drops the name of the request type, if it has one, from a validator namespace
+func fieldPath(namespace string, req interface{}) string {
+	if name := reflect.Indirect(reflect.ValueOf(req)).Type().Name(); name != "" {
+		return strings.TrimPrefix(namespace, name+".")
+	}
+	return namespace
+}
+
+// fieldMessage describes a failed validation rule
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if isCollection(fe.Kind()) {
+			return fmt.Sprintf("must have at least %s items", fe.Param())
+		} else if fe.Kind() == reflect.String {
+			if fe.Param() == "1" {
+				return "must not be empty"
+			}
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		if isCollection(fe.Kind()) {
+			return fmt.Sprintf("must have at most %s items", fe.Param())
+		} else if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "email":
+		return "must be an email address"
+	default:
+		return fmt.Sprintf("failed the %s rule", fe.Tag())
+	}
+}
+
+func isCollection(k reflect.Kind) bool {
+	return k == reflect.Slice || k == reflect.Array || k == reflect.Map
+}
+
+// jsonType names the JSON type a Go type is decoded from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
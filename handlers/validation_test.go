@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edubank/ai"
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestFieldErrors(t *testing.T) {
+	type aiRequest struct {
+		Question string          `json:"question"`
+		Exam     *ai.ExamRequest `json:"exam"`
+	}
+	tests := []struct {
+		name string
+		body string
+		want []FieldError
+	}{
+		{
+			name: "rules",
+			body: `{"exam": {"count": 80, "question_types": ["essay"], "bloom_level": "memorize"}}`,
+			want: []FieldError{
+				{Field: "exam.count", Tag: "max", Param: "50", Message: "must be at most 50"},
+				{Field: "exam.question_types[0]", Tag: "oneof", Param: "multiple_choice true_false short_answer", Message: "must be one of: multiple_choice, true_false, short_answer"},
+				{Field: "exam.bloom_level", Tag: "oneof", Param: "remember understand apply analyze evaluate create", Message: "must be one of: remember, understand, apply, analyze, evaluate, create"},
+			},
+		},
+		{
+			name: "required",
+			body: `{"exam": {"topics": [""]}}`,
+			want: []FieldError{
+				{Field: "exam.topics[0]", Tag: "min", Param: "1", Message: "must not be empty"},
+				{Field: "exam.count", Tag: "required", Message: "is required"},
+			},
+		},
+		{
+			name: "type",
+			body: `{"exam": {"count": "ten"}}`,
+			want: []FieldError{{Field: "exam.count", Tag: "type", Param: "int", Message: "must be a number, not a string"}},
+		},
+		{
+			name: "malformed",
+			body: `{"exam": `,
+		},
+	}
+
+	for _, tt := range tests {
+		var request aiRequest
+		req, _ := http.NewRequest(http.MethodPost, "/api/ai", strings.NewReader(tt.body))
+		err := binding.JSON.Bind(req, &request)
+		if err == nil {
+			t.Errorf("%s: request was accepted", tt.name)
+			continue
+		}
+
+		got := fieldErrors(err, &request)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: fieldErrors = %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: field %d = %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
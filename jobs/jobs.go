@@ -0,0 +1,296 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/edubank/db"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Job is a dataset ingestion queued by UploadDatasetHandler
+type Job struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"-"`
+	DatasetID     int        `json:"dataset_id"`
+	FilePath      string     `json:"-"`
+	Status        string     `json:"status"`
+	ProgressDone  int        `json:"progress_done"`
+	ProgressTotal int        `json:"progress_total"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+
+	lease string // worker_id the job was claimed with
+}
+
+// ProcessFunc runs the ingestion for a claimed job
+type ProcessFunc func(ctx context.Context, job *Job) error
+
+// FinishFunc is called once a worker is done with a job: after its final status is
+// saved, so whoever it tells about the outcome finds the job finished when they look it
+// up, or when the job is handed back (status queued) or taken over by another worker
+// (status running) without an outcome
+type FinishFunc func(job *Job)
+
+const jobColumns = "id, user_id, dataset_id, file_path, status, progress_done, progress_total, error, created_at, started_at, finished_at"
+
+// ErrNotFound is returned by Get when the job does not exist
+var ErrNotFound = errors.New("job not found")
+
+// leaseDuration is how long a claimed job stays with its instance without a heartbeat.
+// Running jobs renew their lease every leaseDuration/3.
+const leaseDuration = time.Minute
+
+// instanceID identifies this process in the leases of the jobs it runs
+var instanceID = newInstanceID()
+
+// claims numbers the claims of this process, so a job it claims again gets a new lease
+var claims atomic.Int64
+
+// errLeaseLost cancels a job taken over by another worker
+var errLeaseLost = errors.New("job lease was taken over")
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// wake nudges idle workers when a job is enqueued, so they don't wait for the next poll
+var wake = make(chan struct{}, 1)
+
+// Enqueue adds an ingestion job for the dataset and returns its ID
+func Enqueue(ctx context.Context, userID, datasetID int, filePath string) (int, error) {
+	var id int
+	err := db.Pool.QueryRow(ctx,
+		"INSERT INTO jobs (user_id, dataset_id, file_path) VALUES ($1, $2, $3) RETURNING id",
+		userID, datasetID, filePath,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Get loads a job by ID
+func Get(ctx context.Context, id int) (*Job, error) {
+	row := db.Pool.QueryRow(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id=$1", id)
+	job, err := scanJob(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// Latest loads the most recent job of a dataset
+func Latest(ctx context.Context, datasetID int) (*Job, error) {
+	row := db.Pool.QueryRow(ctx, "SELECT "+jobColumns+" FROM jobs WHERE dataset_id=$1 ORDER BY id DESC LIMIT 1", datasetID)
+	job, err := scanJob(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// SetProgress records how many units (pages, audio chunks) of the job are finished
+func (j *Job) SetProgress(ctx context.Context, done, total int) error {
+	j.ProgressDone, j.ProgressTotal = done, total
+	_, err := db.Pool.Exec(ctx,
+		"UPDATE jobs SET progress_done=$1, progress_total=$2 WHERE id=$3",
+		done, total, j.ID,
+	)
+	return err
+}
+
+// Start launches the worker pool. Workers stop when ctx is cancelled. finished may be nil.
+// Jobs running on other instances are left alone; a job is only taken over once the
+// lease of the instance running it expires.
+func Start(ctx context.Context, workers int, process ProcessFunc, finished FinishFunc) {
+	for i := 0; i < workers; i++ {
+		go worker(ctx, process, finished)
+	}
+	log.Printf("Started %d ingestion workers as %s", workers, instanceID)
+}
+
+// worker claims and runs jobs until ctx is cancelled
+func worker(ctx context.Context, process ProcessFunc, finished FinishFunc) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		job, err := claim(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("failed to claim job: %v", err)
+		}
+		if job != nil {
+			run(ctx, job, process, finished)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim leases the oldest queued job, or a running job whose lease expired because the
+// instance running it is gone, to this instance and returns it
+func claim(ctx context.Context) (*Job, error) {
+	lease := fmt.Sprintf("%s/%d", instanceID, claims.Add(1))
+	row := db.Pool.QueryRow(ctx,
+		`UPDATE jobs SET status=$1, started_at=NOW(), error='', worker_id=$3, lease_expires_at=NOW() + $4 * INTERVAL '1 second'
+		 WHERE id = (SELECT id FROM jobs
+		             WHERE status=$2 OR (status=$1 AND (lease_expires_at IS NULL OR lease_expires_at < NOW()))
+		             ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		 RETURNING `+jobColumns,
+		StatusRunning, StatusQueued, lease, leaseDuration.Seconds(),
+	)
+	job, err := scanJob(row)
+	if err != nil {
+		return nil, err
+	}
+	job.lease = lease
+	return job, nil
+}
+
+// renewLease extends the lease of a running job. It reports false when the job is no
+// longer leased to this worker, e.g. because another one took it over after a stall.
+func renewLease(ctx context.Context, job *Job) (bool, error) {
+	tag, err := db.Pool.Exec(ctx,
+		"UPDATE jobs SET lease_expires_at=NOW() + $1 * INTERVAL '1 second' WHERE id=$2 AND status=$3 AND worker_id=$4",
+		leaseDuration.Seconds(), job.ID, StatusRunning, job.lease,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// heartbeat renews the lease of job until ctx is done, cancelling the job with
+// errLeaseLost if the lease was taken over
+func heartbeat(ctx context.Context, job *Job, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := renewLease(ctx, job)
+		if err != nil {
+			// Keep going: the lease outlives a couple of failed renewals
+			log.Printf("Job %d: failed to renew lease: %v", job.ID, err)
+			continue
+		}
+		if !ok {
+			log.Printf("Job %d: lease was taken over, stopping", job.ID)
+			cancel(errLeaseLost)
+			return
+		}
+	}
+}
+
+// run processes a claimed job and records the outcome
+func run(ctx context.Context, job *Job, process ProcessFunc, finished FinishFunc) {
+	log.Printf("Job %d: processing %s", job.ID, job.FilePath)
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go heartbeat(jobCtx, job, cancel)
+
+	status, message := StatusDone, ""
+	if err := process(jobCtx, job); err != nil {
+		if ctx.Err() != nil {
+			// Shutting down: hand the job back so another instance, or the next start, picks it up
+			log.Printf("Job %d interrupted: %v", job.ID, err)
+			release(job)
+			job.Status = StatusQueued
+			if finished != nil {
+				finished(job)
+			}
+			return
+		}
+		if errors.Is(context.Cause(jobCtx), errLeaseLost) {
+			// The worker that took the job over records its outcome
+			log.Printf("Job %d abandoned: %v", job.ID, err)
+			if finished != nil {
+				finished(job)
+			}
+			return
+		}
+		log.Printf("Job %d failed: %v", job.ID, err)
+		status, message = StatusFailed, err.Error()
+	}
+	cancel(nil)
+
+	tag, err := db.Pool.Exec(ctx,
+		"UPDATE jobs SET status=$1, error=$2, finished_at=NOW(), lease_expires_at=NULL WHERE id=$3 AND worker_id=$4",
+		status, message, job.ID, job.lease,
+	)
+	if err != nil {
+		log.Printf("Job %d: failed to save status: %v", job.ID, err)
+	} else if tag.RowsAffected() == 0 {
+		log.Printf("Job %d: lease was taken over, not saving its outcome", job.ID)
+		if finished != nil {
+			finished(job)
+		}
+		return
+	}
+
+	job.Status, job.Error = status, message
+	if finished != nil {
+		finished(job)
+	}
+}
+
+// release queues a job leased by this worker again
+func release(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.Pool.Exec(ctx,
+		"UPDATE jobs SET status=$1, worker_id='', lease_expires_at=NULL WHERE id=$2 AND status=$3 AND worker_id=$4",
+		StatusQueued, job.ID, StatusRunning, job.lease,
+	)
+	if err != nil {
+		log.Printf("Job %d: failed to release: %v", job.ID, err)
+	}
+}
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.UserID, &j.DatasetID, &j.FilePath, &j.Status,
+		&j.ProgressDone, &j.ProgressTotal, &j.Error, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
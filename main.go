@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
     "context"
 
+	"github.com/edubank/ai"
 	"github.com/edubank/db"
 	"github.com/edubank/handlers"
+	"github.com/edubank/jobs"
 	"github.com/edubank/middleware"
 
 
@@ -31,6 +34,17 @@ func main() {
 
     fmt.Println("DB connected ✅", pool)
 
+	// Move datasets still kept in dataset.jsonl files into the dataset store
+	if err := ai.ImportDatasets(ctx, "ai/users"); err != nil {
+		log.Fatal("failed to import datasets:", err)
+	}
+	if err := handlers.ReingestDatasets(ctx); err != nil {
+		log.Fatal("failed to queue dataset ingestion:", err)
+	}
+
+	// Start the ingestion workers
+	jobs.Start(ctx, getJobWorkers(), handlers.ProcessJob, handlers.JobFinished)
+
 	// Setup Gin router
 	r := setupRouter()
 
@@ -48,7 +62,7 @@ func setupRouter() *gin.Engine {
 	// Enable CORS
 	r.Use(cors.New(cors.Config{
 		AllowAllOrigins:  true,
-		AllowMethods:     []string{"POST", "GET", "OPTIONS"},
+		AllowMethods:     []string{"POST", "GET", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -69,10 +83,16 @@ func setupRouter() *gin.Engine {
 	}
 
 	// Protected routes
+	// EventSource can't set headers, so the event stream also takes a token in the URL
+	r.GET("/api/datasets/:id/events", middleware.EventsAuthMiddleware(), handlers.DatasetEventsHandler)
+
 	api := r.Group("/api", middleware.AuthMiddleware())
 	{
 		api.POST("/datasets/upload", handlers.UploadDatasetHandler)
     	api.GET("/datasets", handlers.ListDatasetsHandler)
+		api.DELETE("/datasets/:id", handlers.DeleteDatasetHandler)
+		api.POST("/datasets/:id/events/token", handlers.DatasetEventsTokenHandler)
+		api.GET("/jobs/:id", handlers.JobStatusHandler)
 		api.POST("/ai", handlers.AIHandler)
 	}
 
@@ -93,3 +113,12 @@ func getPort() string {
 	}
 	return fmt.Sprintf(":%s", port)
 }
+
+// getJobWorkers returns the number of ingestion workers from env or default
+func getJobWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = 2
+	}
+	return workers
+}
@@ -11,6 +11,9 @@ import (
 
 var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
+// EventsScope is the scope claim of the short-lived tokens that only open a dataset's event stream
+const EventsScope = "events"
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
@@ -27,19 +30,52 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenStr := parts[1]
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-		if err != nil || !token.Valid {
+		claims, ok := parseToken(tokenStr)
+		// scoped tokens travel in URLs and must not grant access to the rest of the API
+		if !ok || claims["scope"] != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
 
 		// optional: set email in context for handlers
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("email", claims["email"])
+		c.Set("email", claims["email"])
+
+		c.Next()
+	}
+}
+
+// EventsAuthMiddleware authenticates the event stream of a dataset. Browsers' EventSource
+// can't send an Authorization header, so besides it the middleware accepts a token with
+// the events scope for the dataset of the :id parameter in the token query parameter.
+func EventsAuthMiddleware() gin.HandlerFunc {
+	bearer := AuthMiddleware()
+	return func(c *gin.Context) {
+		tokenStr := c.Query("token")
+		if tokenStr == "" {
+			bearer(c)
+			return
 		}
 
+		claims, ok := parseToken(tokenStr)
+		if !ok || claims["scope"] != EventsScope || claims["dataset_id"] != c.Param("id") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+
+		c.Set("email", claims["email"])
+
 		c.Next()
 	}
 }
+
+// parseToken checks the signature and expiry of a token and returns its claims
+func parseToken(tokenStr string) (jwt.MapClaims, bool) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+	return claims, true
+}
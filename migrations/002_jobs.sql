@@ -0,0 +1,17 @@
+-- Ingestion jobs processed by the in-process worker pool
+CREATE TABLE IF NOT EXISTS jobs (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  dataset_id INT NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+  file_path TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'queued', -- queued | running | done | failed
+  progress_done INT NOT NULL DEFAULT 0,
+  progress_total INT NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP DEFAULT NOW(),
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs (id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_dataset_idx ON jobs (dataset_id);
@@ -0,0 +1,2 @@
+-- Content type detected from the upload's magic bytes
+ALTER TABLE datasets ADD COLUMN IF NOT EXISTS mime_type TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Format specific details, e.g. the duration of audio/video uploads
+ALTER TABLE datasets ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,18 @@
+-- Chunks extracted from each dataset, replacing the per-user dataset.jsonl files
+CREATE TABLE IF NOT EXISTS dataset_chunks (
+  id BIGSERIAL PRIMARY KEY,
+  dataset_id INT NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+  chunk_id TEXT UNIQUE NOT NULL,
+  seq INT NOT NULL, -- position of the chunk in its dataset
+  version INT NOT NULL,
+  page_start INT NOT NULL DEFAULT 0,
+  page_end INT NOT NULL DEFAULT 0,
+  time_start DOUBLE PRECISION NOT NULL DEFAULT 0,
+  time_end DOUBLE PRECISION NOT NULL DEFAULT 0,
+  heading_path TEXT[] NOT NULL DEFAULT '{}',
+  text TEXT NOT NULL,
+  hash TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS dataset_chunks_dataset_idx ON dataset_chunks (dataset_id, seq);
@@ -0,0 +1,3 @@
+-- Embeddings computed at ingestion, and the model that computed them
+ALTER TABLE dataset_chunks ADD COLUMN IF NOT EXISTS embedding REAL[];
+ALTER TABLE dataset_chunks ADD COLUMN IF NOT EXISTS embedding_model TEXT NOT NULL DEFAULT '';
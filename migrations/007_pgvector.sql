@@ -0,0 +1,11 @@
+-- Embeddings as pgvector vectors, searched through an HNSW index instead of in memory.
+-- The dimension matches EMBEDDING_DIMENSIONS (768); other sizes stay in the embedding column only.
+CREATE EXTENSION IF NOT EXISTS vector;
+
+ALTER TABLE dataset_chunks ADD COLUMN IF NOT EXISTS embedding_vec vector(768);
+
+UPDATE dataset_chunks SET embedding_vec = embedding::vector
+WHERE embedding_vec IS NULL AND cardinality(embedding) = 768;
+
+CREATE INDEX IF NOT EXISTS dataset_chunks_embedding_idx ON dataset_chunks
+  USING hnsw (embedding_vec vector_cosine_ops);
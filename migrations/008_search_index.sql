@@ -0,0 +1,12 @@
+-- Per-user version of the chunks, bumped whenever they change, and the BM25 index
+-- built from them, so questions only rebuild the index when it is out of date
+CREATE TABLE IF NOT EXISTS search_indexes (
+  user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  chunks_version BIGINT NOT NULL DEFAULT 0,
+  bm25_version BIGINT NOT NULL DEFAULT -1, -- chunks_version the index was built from
+  bm25 BYTEA
+);
+
+INSERT INTO search_indexes (user_id, chunks_version)
+SELECT DISTINCT d.user_id, 1 FROM datasets d JOIN dataset_chunks c ON c.dataset_id = d.id
+ON CONFLICT (user_id) DO NOTHING;
@@ -0,0 +1,6 @@
+-- Full-text search over chunk text, the lexical side of hybrid retrieval with pgvector.
+-- The simple configuration doesn't stem, so it works the same for every language.
+ALTER TABLE dataset_chunks ADD COLUMN IF NOT EXISTS text_search tsvector
+  GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED;
+
+CREATE INDEX IF NOT EXISTS dataset_chunks_text_search_idx ON dataset_chunks USING gin (text_search);
@@ -0,0 +1,6 @@
+-- Each running job is leased by the instance working on it, which renews the lease while
+-- the job runs. Jobs whose lease expired belong to an instance that is gone and are taken over.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS worker_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS jobs_running_lease_idx ON jobs (lease_expires_at) WHERE status = 'running';
@@ -13,3 +13,13 @@ CREATE TABLE IF NOT EXISTS users (
   created_at TIMESTAMP DEFAULT NOW()
 );
 
+
+CREATE TABLE IF NOT EXISTS datasets (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  filename TEXT NOT NULL,
+  file_url TEXT NOT NULL,
+  size_bytes BIGINT NOT NULL DEFAULT 0,
+  uploaded_at TIMESTAMP DEFAULT NOW(),
+  UNIQUE (user_id, filename)
+);